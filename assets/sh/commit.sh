@@ -9,18 +9,58 @@ AUTO_ACCEPT=false
 DRY_RUN=false
 VERBOSE=false
 FORCE_SETUP=false
+USE_RELAY=false
+REDACT_SECRETS=true
+USE_CACHE=true
 API_KEY=""
 API_URL="https://openrouter.ai/api/v1/chat/completions"
 AI_MODEL=""
+PRESET_MODEL=""
+DEFAULT_MODEL="openrouter/free"
+PRODUCT_NAME="Commit"
+PROVIDER_NAME="OpenRouter"
+MODELS_URL="https://openrouter.ai/models"
 CONFIG_API_KEY=""
 CONFIG_MODEL=""
+CONFIG_TOKEN=""
+CONFIG_RETRIES=""
+FALLBACK_MODELS=()
+FALLBACK_MODELS_JSON="[]"
+USE_MODEL_ROUTING=false
+RELAY_TOKEN=""
 AUTH_HEADER_FILE=""
+RESPONSE_HEADER_FILE=""
 MAX_DIFF_BYTES=1048576
+MESSAGE_LANGUAGE=""
 CONFIG_DIR_MANAGED=true
+SCRIPT_URL="http://localhost"
+SCRIPT_VERSION="dev"
+SCRIPT_REVISION=""
 CONFIG_FILE="${COMMIT_CONFIG:-${XDG_CONFIG_HOME:-$HOME/.config}/commit/config.json}"
+CACHE_DIR="${XDG_CACHE_HOME:-$HOME/.cache}/commit"
+CACHE_TTL_SECONDS=86400
+CACHE_MAX_ENTRIES=100
+MAX_RETRIES=2
+RETRY_DELAY=1
+MAX_RETRY_DELAY=60
 TTY_INPUT="${COMMIT_TTY_INPUT:-/dev/tty}"
 TTY_OUTPUT="${COMMIT_TTY_OUTPUT:-/dev/tty}"
 
+# The server renders the template lines below; until then they are comments.
+#{{ assign "SCRIPT_URL" .Domain }}
+#{{ assign "SCRIPT_VERSION" .Version }}
+#{{ assign "SCRIPT_REVISION" .Revision }}
+#{{ assign "PRODUCT_NAME" .Brand.Name }}
+#{{ assign "PROVIDER_NAME" .Brand.ProviderName }}
+#{{ assign "API_URL" .Brand.APIURL }}
+#{{ assign "DEFAULT_MODEL" .Brand.DefaultModel }}
+#{{ if .Brand.ProviderURL }}MODELS_URL=#{{ quote .Brand.ModelsURL }}#{{ end }}
+#{{ assign "USE_RELAY" .Relay }}
+#{{ assign "PRESET_MODEL" .Defaults.Model }}
+#{{ assign "AUTO_ACCEPT" .Defaults.AutoAccept }}
+#{{ assign "MAX_DIFF_BYTES" .Defaults.MaxDiffBytes }}
+#{{ assign "MESSAGE_LANGUAGE" .Defaults.Language }}
+
 if [ -n "${COMMIT_CONFIG:-}" ]; then
     CONFIG_DIR_MANAGED=false
 fi
@@ -60,21 +100,28 @@ unstaged_diff_output=""
 combined_diff_output=""
 diff_stat_output=""
 files=""
-response=""
+response_body=""
 http_status=""
+retry_after=""
 message=""
 suggestion=""
 previous_message=""
+cache_key=""
+read_cache=true
 
-cleanup_auth_header() {
+cleanup_temp_files() {
     if [ -n "$AUTH_HEADER_FILE" ]; then
         rm -f "$AUTH_HEADER_FILE"
         AUTH_HEADER_FILE=""
     fi
+    if [ -n "$RESPONSE_HEADER_FILE" ]; then
+        rm -f "$RESPONSE_HEADER_FILE"
+        RESPONSE_HEADER_FILE=""
+    fi
 }
 
-trap cleanup_auth_header EXIT
-trap 'cleanup_auth_header; exit 1' HUP INT TERM
+trap cleanup_temp_files EXIT
+trap 'cleanup_temp_files; exit 1' HUP INT TERM
 
 log_verbose() {
     if [ "$VERBOSE" = true ]; then
@@ -91,6 +138,238 @@ format_changed_files() {
     }'
 }
 
+# redact_diff reads a diff on stdin. With "redact" it prints the diff with
+# likely secrets replaced by [REDACTED:<rule>] placeholders; with "report" it
+# prints "<file>:<line> <rules>" for each changed line instead.
+redact_diff() {
+    awk -v mode="$1" '
+    function rep(class, n,    s) {
+        s = ""
+        while (n-- > 0) s = s class
+        return s
+    }
+    function hit(rule) {
+        if (index(", " rules ", ", ", " rule ", ") == 0) rules = rules (rules == "" ? "" : ", ") rule
+    }
+    function redact(re, rule, replacement) {
+        if (gsub(re, replacement == "" ? "[REDACTED:" rule "]" : replacement, body) > 0) hit(rule)
+    }
+    # redact_value keeps the key matched by prefix and replaces the value.
+    function redact_value(prefix, value, rule,    rest, out, start, len) {
+        out = ""
+        rest = body
+        while (rest != "" && match(tolower(rest), prefix value)) {
+            start = RSTART
+            len = RLENGTH
+            match(tolower(substr(rest, start, len)), prefix)
+            out = out substr(rest, 1, start + RLENGTH - 1) "[REDACTED:" rule "]"
+            rest = substr(rest, start + len)
+            hit(rule)
+        }
+        body = out rest
+    }
+    # redact_bare_value replaces an unquoted value that ends the line after a
+    # secret-like key, but only when it mixes letters and digits, so
+    # identifiers such as `password: hashedPassword,` are kept.
+    function redact_bare_value(    start, len, value) {
+        if (!match(tolower(body), key bare "[,;]?[ \t]*$")) return
+        start = RSTART
+        len = RLENGTH
+        match(tolower(substr(body, start, len)), key)
+        start += RLENGTH
+        value = substr(body, start, len - RLENGTH)
+        sub(/[,;]?[ \t]*$/, "", value)
+        if (length(value) < 8 || value !~ /[0-9]/ || value !~ /[A-Za-z]/) return
+        body = substr(body, 1, start - 1) "[REDACTED:secret-assignment]" substr(body, start + length(value))
+        hit("secret-assignment")
+    }
+    function env_file(path,    name) {
+        name = path
+        sub(/.*\//, "", name)
+        return name ~ /^\.env(\.[^.]+)*$/ && name !~ /\.(example|sample|template|dist)$/
+    }
+    BEGIN {
+        key = "[a-z0-9_.-]*(password|passwd|secret|token|api_?key|access_?key|private_?key|credential)[a-z0-9_.-]*[\"\047]?[ \t]*(:=|=|:)[ \t]*"
+        plain = "[^[ \t\"\047$<>{}()]"
+        assigned = "(\"" rep(plain, 8) plain "*\"|\047" rep(plain, 8) plain "*\047)"
+        bare = "[a-z0-9_+/=-]+"
+        env_key = "^[ \t]*(export[ \t]+)?[a-z_][a-z0-9_]*[ \t]*=[ \t]*"
+        env_value = "(\"[^\"]+\"|\047[^\047]+\047|[^[ \t\"\047#].*)"
+    }
+    /^diff --git / {
+        in_header = 1
+        in_key = 0
+    }
+    in_header && /^--- / {
+        old_path = substr($0, 5)
+        sub(/^a\//, "", old_path)
+    }
+    in_header && /^\+\+\+ / {
+        path = substr($0, 5)
+        sub(/^b\//, "", path)
+        if (path == "/dev/null") path = old_path
+        in_env = env_file(path)
+    }
+    in_header && /^@@ / {
+        in_header = 0
+    }
+    /^@@ / {
+        split(substr($2, 2), old_range, ",")
+        split(substr($3, 2), new_range, ",")
+        old_line = old_range[1]
+        new_line = new_range[1]
+        if (mode == "redact") print
+        next
+    }
+    in_header || !/^[-+ ]/ {
+        if (mode == "redact") print
+        next
+    }
+    {
+        prefix = substr($0, 1, 1)
+        body = substr($0, 2)
+        rules = ""
+        if (prefix == "-") {
+            line = old_line++
+        } else {
+            line = new_line++
+            if (prefix == " ") old_line++
+        }
+
+        if (in_env) redact_value(env_key, env_value, "env-value")
+        if (in_key) {
+            if (body ~ /-----END[ A-Z0-9]*PRIVATE KEY-----/) {
+                in_key = 0
+            } else {
+                body = "[REDACTED:private-key]"
+                hit("private-key")
+            }
+        } else if (body ~ /-----BEGIN[ A-Z0-9]*PRIVATE KEY-----/ && body !~ /PRIVATE KEY-----.*-----END/) {
+            in_key = 1
+        }
+        redact("PRIVATE KEY-----[^-]+-----END", "private-key", "PRIVATE KEY-----[REDACTED:private-key]-----END")
+        redact("(AKIA|ASIA)" rep("[0-9A-Z]", 16), "aws-access-key")
+        redact("gh[pousr]_" rep("[A-Za-z0-9]", 36) "|github_pat_" rep("[A-Za-z0-9_]", 22) "[A-Za-z0-9_]*", "github-token")
+        redact("glpat-" rep("[A-Za-z0-9_-]", 20), "gitlab-token")
+        redact("xox[abposr]-" rep("[A-Za-z0-9-]", 10) "[A-Za-z0-9-]*", "slack-token")
+        redact("[rs]k_live_" rep("[A-Za-z0-9]", 16) "[A-Za-z0-9]*", "stripe-key")
+        redact_value("(^|[^a-z0-9_-])", "sk-" rep("[a-z0-9_-]", 32) "[a-z0-9_-]*", "api-key")
+        redact("AIza" rep("[0-9A-Za-z_-]", 35), "google-api-key")
+        redact("eyJ[A-Za-z0-9_-]+[.]eyJ[A-Za-z0-9_-]+[.][A-Za-z0-9_-]+", "jwt")
+        redact("://[^/:@ \t[]+:[^/@ \t[]+@", "url-credentials", "://[REDACTED:url-credentials]@")
+        if (!in_env) {
+            redact_value(key, assigned, "secret-assignment")
+            redact_bare_value()
+        }
+
+        if (mode == "redact") {
+            print prefix body
+        } else if (rules != "") {
+            print path ":" line " " rules
+        }
+    }'
+}
+
+redact_secrets() {
+    local findings
+
+    log_verbose "Scanning the diff for secrets"
+    findings=$(printf '%s\n' "$combined_diff_output" | redact_diff report)
+    if [ -z "$findings" ]; then
+        return
+    fi
+
+    combined_diff_output=$(printf '%s\n' "$combined_diff_output" | redact_diff redact)
+    printf "${YELLOW}Redacted possible secrets before sending the diff:${NC}\n"
+    printf '%s\n' "$findings" | sed 's/^/  /'
+    printf "Use --no-redact to send the diff unchanged.\n"
+}
+
+hash_sha256() {
+    if command -v sha256sum >/dev/null 2>&1; then
+        sha256sum | cut -d ' ' -f 1
+    elif command -v shasum >/dev/null 2>&1; then
+        shasum -a 256 | cut -d ' ' -f 1
+    elif command -v openssl >/dev/null 2>&1; then
+        openssl dgst -sha256 -r | cut -d ' ' -f 1
+    fi
+}
+
+# set_cache_key keys the cache on the endpoint and the exact request body,
+# which holds the model, the prompt and the diff. Requests carrying a
+# suggestion are not cached.
+set_cache_key() {
+    cache_key=""
+    if [ "$USE_CACHE" = true ] && [ -z "$suggestion" ]; then
+        cache_key=$(printf '%s\n%s' "$1" "$2" | hash_sha256)
+    fi
+}
+
+read_cached_message() {
+    local cache_file="$CACHE_DIR/$cache_key"
+    local mode
+    local modified
+
+    if [ "$read_cache" != true ] || [ -z "$cache_key" ] || [ ! -f "$cache_file" ] || [ -L "$cache_file" ]; then
+        return 1
+    fi
+    if mode=$(stat -c '%a' "$cache_file" 2>/dev/null) || mode=$(stat -f '%Lp' "$cache_file" 2>/dev/null); then
+        if [ "${mode: -2}" != "00" ]; then
+            return 1
+        fi
+    fi
+    modified=$(stat -c '%Y' "$cache_file" 2>/dev/null || stat -f '%m' "$cache_file" 2>/dev/null) || return 1
+    if [ $(($(date +%s) - modified)) -ge "$CACHE_TTL_SECONDS" ]; then
+        return 1
+    fi
+
+    message=$(cat "$cache_file") || return 1
+    [ -n "$message" ]
+}
+
+# write_cached_message stores message under cache_key, then drops expired
+# entries and all but the newest CACHE_MAX_ENTRIES.
+write_cached_message() {
+    if [ -z "$cache_key" ] || [ -z "$message" ]; then
+        return
+    fi
+
+    if ! (
+        umask 077
+        mkdir -p "$CACHE_DIR" && chmod 700 "$CACHE_DIR" || exit 1
+        temp_file=$(mktemp "$CACHE_DIR/.tmp.XXXXXX") || exit 1
+        if ! printf '%s' "$message" > "$temp_file" || ! mv "$temp_file" "$CACHE_DIR/$cache_key"; then
+            rm -f "$temp_file"
+            exit 1
+        fi
+        find "$CACHE_DIR" -type f -mmin +$((CACHE_TTL_SECONDS / 60)) -exec rm -f {} +
+        ls -t "$CACHE_DIR" | tail -n +$((CACHE_MAX_ENTRIES + 1)) | while read -r name; do
+            rm -f "$CACHE_DIR/$name"
+        done
+    ); then
+        log_verbose "Could not write the message cache in " "$CACHE_DIR"
+    fi
+}
+
+use_cached_message() {
+    if ! read_cached_message; then
+        return 1
+    fi
+    printf "Using the message cached for this diff. Pass --no-cache to generate a new one.\n"
+    previous_message="$message"
+}
+
+format_size() {
+    local bytes="$1"
+    if [ $((bytes % 1048576)) -eq 0 ]; then
+        printf "%d MiB" $((bytes / 1048576))
+    elif [ $((bytes % 1024)) -eq 0 ]; then
+        printf "%d KiB" $((bytes / 1024))
+    else
+        printf "%d bytes" "$bytes"
+    fi
+}
+
 is_valid_model() {
     local candidate="$1"
     [ -n "$candidate" ] && [[ "$candidate" != *[[:space:]]* ]]
@@ -100,35 +379,45 @@ show_help() {
     local status="${1:-0}"
     log_verbose "Displaying help message"
     printf "${GREEN}Usage: commit.sh [options]${NC}\n"
+    printf "Version: %s%s\n" "$SCRIPT_VERSION" "${SCRIPT_REVISION:+ ($SCRIPT_REVISION)}"
     printf "\n"
     printf "${YELLOW}Options:${NC}\n"
     printf "  ${GREEN}%-22s${NC} %s\n" "--dry-run" "Run the script without making any changes"
     printf "  ${GREEN}%-22s${NC} %s\n" "-y, --yes" "Accept the generated message without confirmation"
-    printf "  ${GREEN}%-22s${NC} %s\n" "-m, --model" "Override the OpenRouter model"
+    printf "  ${GREEN}%-22s${NC} %s\n" "-m, --model" "Override the $PROVIDER_NAME model"
     printf "  ${GREEN}%-22s${NC} %s\n" "-v, --verbose" "Enable verbose logging"
     printf "  ${GREEN}%-22s${NC} %s\n" "--setup" "Configure the saved API key and model"
+    printf "  ${GREEN}%-22s${NC} %s\n" "--relay" "$(printf 'Generate the message through %s with its key' "$SCRIPT_URL")"
+    printf "  ${GREEN}%-22s${NC} %s\n" "--no-relay" "$(printf 'Send the diff to %s with your own key' "$PROVIDER_NAME")"
+    printf "  ${GREEN}%-22s${NC} %s\n" "--no-redact" "Send the diff without redacting likely secrets"
+    printf "  ${GREEN}%-22s${NC} %s\n" "--no-cache" "Generate a new message even if one is cached for the diff"
     printf "  ${GREEN}%-22s${NC} %s\n" "-h, --help" "Display this help message"
     printf "\n"
     printf "${YELLOW}Configuration:${NC}\n"
     printf "  ${GREEN}%s${NC}\n" "$CONFIG_FILE"
-    printf "  Environment: OPENROUTER_API_KEY, COMMIT_MODEL\n"
-    printf "  Model IDs: https://openrouter.ai/models\n"
-    printf "  Default model: openrouter/free\n"
-    printf "  Maximum diff size: 1 MiB\n"
+    printf "  Environment: OPENROUTER_API_KEY, COMMIT_MODEL, COMMIT_TOKEN, COMMIT_RETRIES\n"
+    if [ -n "$MODELS_URL" ]; then
+        printf "  Model IDs: %s\n" "$MODELS_URL"
+    fi
+    printf "  Default model: %s\n" "${PRESET_MODEL:-$DEFAULT_MODEL}"
+    printf "  Maximum diff size: %s\n" "$(format_size "$MAX_DIFF_BYTES")"
+    if [ -n "$MESSAGE_LANGUAGE" ]; then
+        printf "  Message language: %s\n" "$MESSAGE_LANGUAGE"
+    fi
     printf "\n"
     printf "${YELLOW}Example Usage:${NC}\n"
     printf "  ${GREEN}Basic usage:${NC}\n"
-    printf "    curl -fsSL http://localhost | bash\n"
+    printf "    curl -fsSL %s | bash\n" "$SCRIPT_URL"
     printf "  ${GREEN}Accept without confirmation:${NC}\n"
-    printf "    curl -fsSL http://localhost | bash -s -- --yes\n"
+    printf "    curl -fsSL %s | bash -s -- --yes\n" "$SCRIPT_URL"
     printf "  ${GREEN}Dry run:${NC}\n"
-    printf "    curl -fsSL http://localhost | bash -s -- --dry-run\n"
+    printf "    curl -fsSL %s | bash -s -- --dry-run\n" "$SCRIPT_URL"
     printf "  ${GREEN}Run setup again:${NC}\n"
-    printf "    curl -fsSL http://localhost | bash -s -- --setup\n"
+    printf "    curl -fsSL %s | bash -s -- --setup\n" "$SCRIPT_URL"
     printf "  ${GREEN}Override the model:${NC}\n"
-    printf "    curl -fsSL http://localhost | bash -s -- --model openrouter/auto\n"
+    printf "    curl -fsSL %s | bash -s -- --model %s\n" "$SCRIPT_URL" "$DEFAULT_MODEL"
     printf "  ${GREEN}Enable verbose logging:${NC}\n"
-    printf "    curl -fsSL http://localhost | bash -s -- --verbose\n"
+    printf "    curl -fsSL %s | bash -s -- --verbose\n" "$SCRIPT_URL"
     printf "\n"
     log_verbose "Help message displayed"
     exit "$status"
@@ -140,6 +429,7 @@ load_config() {
     fi
 
     local mode
+    local model
     if mode=$(stat -c '%a' "$CONFIG_FILE" 2>/dev/null) || mode=$(stat -f '%Lp' "$CONFIG_FILE" 2>/dev/null); then
         if [ "${mode: -2}" != "00" ]; then
             printf "${RED}Config file must not be accessible by group or others.${NC}\n"
@@ -160,19 +450,37 @@ load_config() {
 
     CONFIG_API_KEY=$(jq -r '.api_key // empty' "$CONFIG_FILE")
     CONFIG_MODEL=$(jq -r '.model // empty' "$CONFIG_FILE")
+    if ! jq -e '(.fallback_models == null) or ((.fallback_models | type) == "array" and all(.fallback_models[]; type == "string" and length > 0 and (test("\\s") | not)))' "$CONFIG_FILE" >/dev/null 2>&1; then
+        printf "${RED}Invalid fallback_models in %s. Use an array of model IDs without whitespace.${NC}\n" "$CONFIG_FILE"
+        exit 1
+    fi
+
+    if ! jq -e '(.retries == null) or ((.retries | type) == "number" and .retries == (.retries | floor) and .retries >= 0 and .retries <= 10)' "$CONFIG_FILE" >/dev/null 2>&1; then
+        printf "${RED}Invalid retries in %s. Use a whole number from 0 to 10.${NC}\n" "$CONFIG_FILE"
+        exit 1
+    fi
+
+    CONFIG_TOKEN=$(jq -r '.token // empty' "$CONFIG_FILE")
+    CONFIG_RETRIES=$(jq -r '.retries // empty' "$CONFIG_FILE")
+    FALLBACK_MODELS_JSON=$(jq -c '.fallback_models // []' "$CONFIG_FILE")
+    FALLBACK_MODELS=()
+    while IFS= read -r model; do
+        FALLBACK_MODELS+=("$model")
+    done < <(jq -r '.fallback_models // [] | .[]' "$CONFIG_FILE")
 }
 
 setup_config() {
     local api_key
     local existing_api_key="$CONFIG_API_KEY"
-    local existing_model="${CONFIG_MODEL:-openrouter/free}"
+    local existing_model="${CONFIG_MODEL:-$DEFAULT_MODEL}"
     local model
     local config_dir
     local config_dir_existed=false
     local temp_file
+    local existing_config="{}"
 
     exec 3< "$TTY_INPUT" || return 1
-    printf "${YELLOW}Let's configure Commit.${NC}\n" >> "$TTY_OUTPUT"
+    printf "${YELLOW}Let's configure %s.${NC}\n" "$PRODUCT_NAME" >> "$TTY_OUTPUT"
 
     while true; do
         if [ -n "$existing_api_key" ]; then
@@ -224,11 +532,15 @@ setup_config() {
         chmod 700 "$config_dir" || return 1
     fi
     temp_file=$(mktemp "$CONFIG_FILE.tmp.XXXXXX") || return 1
+    if [ -f "$CONFIG_FILE" ]; then
+        existing_config=$(cat "$CONFIG_FILE") || return 1
+    fi
 
     if ! jq -n \
+        --argjson existing "$existing_config" \
         --arg api_key "$CONFIG_API_KEY" \
         --arg model "$CONFIG_MODEL" '
-        {
+        $existing + {
             api_key: $api_key,
             model: $model
         } | with_entries(select(.value != ""))' > "$temp_file"; then
@@ -247,18 +559,43 @@ setup_config() {
     printf "${GREEN}Saved configuration to %s${NC}\n" "$CONFIG_FILE" >> "$TTY_OUTPUT"
 }
 
-configure_openrouter() {
-    AI_MODEL="${AI_MODEL:-${COMMIT_MODEL:-${CONFIG_MODEL:-openrouter/free}}}"
+configure_provider() {
+    AI_MODEL="${AI_MODEL:-${COMMIT_MODEL:-${CONFIG_MODEL:-${PRESET_MODEL:-$DEFAULT_MODEL}}}}"
     if ! is_valid_model "$AI_MODEL"; then
-        printf "${RED}Invalid OpenRouter model. Use a non-empty model ID without whitespace.${NC}\n"
+        printf "${RED}Invalid %s model. Use a non-empty model ID without whitespace.${NC}\n" "$PROVIDER_NAME"
         exit 1
     fi
+    if [[ "$API_URL" == https://openrouter.ai/* ]]; then
+        USE_MODEL_ROUTING=true
+    fi
     if [ -z "$API_KEY" ]; then
         API_KEY="${OPENROUTER_API_KEY:-$CONFIG_API_KEY}"
     fi
     [ -n "$API_KEY" ]
 }
 
+configure_relay() {
+    AI_MODEL="${AI_MODEL:-${COMMIT_MODEL:-$PRESET_MODEL}}"
+    RELAY_TOKEN="${COMMIT_TOKEN:-$CONFIG_TOKEN}"
+    if [ -n "$AI_MODEL" ] && ! is_valid_model "$AI_MODEL"; then
+        printf "${RED}Invalid model. Use a non-empty model ID without whitespace.${NC}\n"
+        exit 1
+    fi
+}
+
+configure_retries() {
+    MAX_RETRIES="${COMMIT_RETRIES:-${CONFIG_RETRIES:-$MAX_RETRIES}}"
+    RETRY_DELAY="${COMMIT_RETRY_DELAY:-$RETRY_DELAY}"
+    if ! [[ "$MAX_RETRIES" =~ ^[0-9]+$ ]] || [ "$MAX_RETRIES" -gt 10 ]; then
+        printf "${RED}Invalid COMMIT_RETRIES. Use a whole number from 0 to 10.${NC}\n"
+        exit 1
+    fi
+    if ! [[ "$RETRY_DELAY" =~ ^[0-9]+$ ]]; then
+        printf "${RED}Invalid COMMIT_RETRY_DELAY. Use a whole number of seconds.${NC}\n"
+        exit 1
+    fi
+}
+
 parse_arguments() {
     log_verbose "Parsing command line arguments"
     while [[ $# -gt 0 ]]; do
@@ -280,7 +617,7 @@ parse_arguments() {
                     printf "${RED}--model requires a value.${NC}\n"
                     exit 2
                 fi
-                log_verbose "OpenRouter model set to: " "$AI_MODEL"
+                log_verbose "Model set to: " "$AI_MODEL"
                 shift
                 ;;
             -m|--model)
@@ -289,7 +626,7 @@ parse_arguments() {
                     exit 2
                 fi
                 AI_MODEL=$2
-                log_verbose "OpenRouter model set to: " "$AI_MODEL"
+                log_verbose "Model set to: " "$AI_MODEL"
                 shift 2
                 ;;
             -v|--verbose)
@@ -301,6 +638,26 @@ parse_arguments() {
                 FORCE_SETUP=true
                 shift
                 ;;
+            --relay)
+                USE_RELAY=true
+                log_verbose "Relay mode enabled"
+                shift
+                ;;
+            --no-relay)
+                USE_RELAY=false
+                log_verbose "Relay mode disabled"
+                shift
+                ;;
+            --no-redact)
+                REDACT_SECRETS=false
+                log_verbose "Secret redaction disabled"
+                shift
+                ;;
+            --no-cache)
+                USE_CACHE=false
+                log_verbose "Message cache disabled"
+                shift
+                ;;
             -h|--help)
                 log_verbose "Help option selected"
                 show_help 0
@@ -362,12 +719,117 @@ get_diff_output() {
 
     diff_size=$(printf '%s' "$combined_diff_output" | wc -c | tr -d '[:space:]')
     if [ "$diff_size" -gt "$MAX_DIFF_BYTES" ]; then
-        printf "${RED}Diff is too large. Maximum size is 1 MiB.${NC}\n"
+        printf "${RED}Diff is too large. Maximum size is %s.${NC}\n" "$(format_size "$MAX_DIFF_BYTES")"
         exit 1
     fi
+
+    if [ "$REDACT_SECRETS" = true ]; then
+        redact_secrets
+    fi
     log_verbose "Diff output retrieved successfully"
 }
 
+# post_json posts request_json to url and sets http_status, response_body and
+# retry_after. http_status is 000 when the server could not be reached.
+post_json() {
+    local url="$1"
+    local request_json="$2"
+    local bearer="$3"
+    local max_time="$4"
+    local response
+
+    umask 077
+    AUTH_HEADER_FILE=$(mktemp "${TMPDIR:-/tmp}/commit-auth.XXXXXX") || exit 1
+    RESPONSE_HEADER_FILE=$(mktemp "${TMPDIR:-/tmp}/commit-headers.XXXXXX") || exit 1
+    if [ -n "$bearer" ] && ! printf 'Authorization: Bearer %s\n' "$bearer" > "$AUTH_HEADER_FILE"; then
+        cleanup_temp_files
+        exit 1
+    fi
+
+    if response=$(printf '%s' "$request_json" | curl -sS --connect-timeout 10 --max-time "$max_time" -D "$RESPONSE_HEADER_FILE" -w "\n%{http_code}" -X POST "$url" -H "Content-Type: application/json" -H "Accept: application/json" -H "@$AUTH_HEADER_FILE" -d @-); then
+        http_status=$(echo "$response" | tail -n1)
+        response_body=$(echo "$response" | sed '$d')
+    else
+        http_status="000"
+        response_body=""
+    fi
+    retry_after=$(tr -d '\r' < "$RESPONSE_HEADER_FILE" | sed -n 's/^[Rr][Ee][Tt][Rr][Yy]-[Aa][Ff][Tt][Ee][Rr]:[[:space:]]*//p' | tail -n1)
+    retry_after=$(retry_after_seconds "$retry_after")
+    cleanup_temp_files
+    log_verbose "Received HTTP status: " "$http_status"
+}
+
+# retry_after_seconds prints a Retry-After value as seconds from now. It
+# accepts delay seconds and HTTP dates, and prints nothing for values it
+# cannot parse.
+retry_after_seconds() {
+    local value="$1"
+    local target
+
+    if [[ "$value" =~ ^[0-9]+$ ]]; then
+        echo "$value"
+        return
+    fi
+    if [ -z "$value" ]; then
+        return
+    fi
+
+    target=$(date -u -d "$value" +%s 2>/dev/null || LC_ALL=C date -u -j -f "%a, %d %b %Y %H:%M:%S GMT" "$value" +%s 2>/dev/null)
+    if [[ "$target" =~ ^[0-9]+$ ]]; then
+        target=$((target - $(date +%s)))
+        echo $((target > 0 ? target : 0))
+    fi
+}
+
+is_retryable_status() {
+    case "$1" in
+        000|429|500|502|503|504) return 0 ;;
+        *) return 1 ;;
+    esac
+}
+
+# post_with_retries calls post_json with all but its first argument, which
+# names the server in messages. Unreachable servers, 429 and 5xx responses
+# are retried up to MAX_RETRIES times, waiting RETRY_DELAY seconds and
+# doubling, or as long as Retry-After asks in seconds or as an HTTP date. A
+# Retry-After over MAX_RETRY_DELAY gives up instead.
+post_with_retries() {
+    local name="$1"
+    local attempt=0
+    local delay
+    local problem
+
+    shift
+    while true; do
+        post_json "$@"
+        if [ "$http_status" = "200" ]; then
+            return 0
+        fi
+        if ! is_retryable_status "$http_status" || [ "$attempt" -ge "$MAX_RETRIES" ]; then
+            return 1
+        fi
+
+        delay=$((RETRY_DELAY * (1 << attempt)))
+        if [[ "$retry_after" =~ ^[0-9]+$ ]]; then
+            if [ "$retry_after" -gt "$MAX_RETRY_DELAY" ]; then
+                log_verbose "Not retrying, Retry-After is " "${retry_after}s"
+                return 1
+            fi
+            delay="$retry_after"
+        elif [ "$delay" -gt "$MAX_RETRY_DELAY" ]; then
+            delay="$MAX_RETRY_DELAY"
+        fi
+
+        attempt=$((attempt + 1))
+        problem="returned HTTP $http_status"
+        if [ "$http_status" = "000" ]; then
+            problem="could not be reached"
+        fi
+        printf "${YELLOW}%s %s. Retrying in %ss (%d/%d).${NC}\n" "$name" "$problem" "$delay" "$attempt" "$MAX_RETRIES"
+        sleep "$delay"
+    done
+}
+
 get_commit_message() {
     log_verbose "Starting to get commit message"
     get_diff_output
@@ -375,10 +837,18 @@ get_commit_message() {
     log_verbose "Building request JSON"
     local system_prompt="$PROMPT"
     local request_json
-    local response_body
+
+    if [ -n "$MESSAGE_LANGUAGE" ]; then
+        system_prompt=$(printf '%s\n\nWrite the description in the language with the BCP 47 tag "%s". Keep the type and scope in English.' "$system_prompt" "$MESSAGE_LANGUAGE")
+    fi
+
+    if [ "$USE_RELAY" = true ]; then
+        get_relay_commit_message
+        return
+    fi
 
     if [ -n "$suggestion" ] && [ -n "$previous_message" ]; then
-        system_prompt=$(printf '%s\n\nThe developer rejected this commit message: "%s"\nThe developer wants the commit message to: %s\nGenerate a completely new commit message that incorporates the developer feedback. Still follow all formatting rules above.' "$PROMPT" "$previous_message" "$suggestion")
+        system_prompt=$(printf '%s\n\nThe developer rejected this commit message: "%s"\nThe developer wants the commit message to: %s\nGenerate a completely new commit message that incorporates the developer feedback. Still follow all formatting rules above.' "$system_prompt" "$previous_message" "$suggestion")
     fi
 
     request_json=$(printf '%s' "$combined_diff_output" | jq -Rs \
@@ -395,30 +865,40 @@ get_commit_message() {
             temperature: 0.2,
             max_tokens: 200
         }')
+    if [ "$USE_MODEL_ROUTING" = true ] && [ "${#FALLBACK_MODELS[@]}" -gt 0 ]; then
+        request_json=$(printf '%s' "$request_json" | jq --argjson fallbacks "$FALLBACK_MODELS_JSON" '.models = [.model] + $fallbacks')
+    fi
     log_verbose "Request JSON: \n" "$request_json"
-    log_verbose "Sending request directly to OpenRouter"
 
-    umask 077
-    AUTH_HEADER_FILE=$(mktemp "${TMPDIR:-/tmp}/commit-auth.XXXXXX") || exit 1
-    if ! printf 'Authorization: Bearer %s\n' "$API_KEY" > "$AUTH_HEADER_FILE"; then
-        cleanup_auth_header
-        exit 1
+    set_cache_key "$API_URL" "$request_json"
+    if use_cached_message; then
+        return
     fi
+    log_verbose "Sending request directly to $PROVIDER_NAME"
 
-    if ! response=$(printf '%s' "$request_json" | curl -sS --connect-timeout 10 --max-time 60 -w "\n%{http_code}" -X POST "$API_URL" -H "Content-Type: application/json" -H "@$AUTH_HEADER_FILE" -d @-); then
-        cleanup_auth_header
-        printf "${RED}Failed to connect to OpenRouter.${NC}\n"
-        exit 1
+    local models=("$AI_MODEL")
+    local model
+    local answered_model
+    if [ "$USE_MODEL_ROUTING" != true ]; then
+        models+=("${FALLBACK_MODELS[@]}")
     fi
-    cleanup_auth_header
-
-    http_status=$(echo "$response" | tail -n1)
-    response_body=$(echo "$response" | sed '$d')
-    log_verbose "Received HTTP status: " "$http_status"
+    for model in "${models[@]}"; do
+        if [ "$model" != "$AI_MODEL" ]; then
+            printf "${YELLOW}Trying fallback model %s.${NC}\n" "$model"
+            request_json=$(printf '%s' "$request_json" | jq --arg model "$model" '.model = $model')
+        fi
+        if post_with_retries "$PROVIDER_NAME" "$API_URL" "$request_json" "$API_KEY" 60 || ! is_retryable_status "$http_status"; then
+            break
+        fi
+    done
 
     suggestion=""
 
-    if [ -z "$http_status" ] || [ "$http_status" -ne 200 ]; then
+    if [ "$http_status" = "000" ]; then
+        printf "${RED}Failed to connect to %s.${NC}\n" "$PROVIDER_NAME"
+        exit 1
+    fi
+    if [ "$http_status" != "200" ]; then
         log_verbose "Error: Non-200 status code received: " "$http_status"
         message=$(printf '%s' "$response_body" | jq -r '.error.message // "AI request failed"' 2>/dev/null)
         if [ -z "$message" ]; then
@@ -429,10 +909,68 @@ get_commit_message() {
     fi
 
     message=$(printf '%s' "$response_body" | jq -r '.choices[0].message.content // empty' | tr '\n' ' ')
+    answered_model=$(printf '%s' "$response_body" | jq -r '.model // empty' 2>/dev/null)
+    printf "Generated with %s.\n" "${answered_model:-$model}"
     log_verbose "Commit message received from AI service"
     log_verbose "AI service response: " "$message"
 
     previous_message="$message"
+    write_cached_message
+}
+
+get_relay_commit_message() {
+    local relay_url="$SCRIPT_URL/api/v1/generate"
+    local request_json
+    local answered_model
+
+    log_verbose "Building relay request JSON"
+    request_json=$(printf '%s' "$combined_diff_output" | jq -Rs \
+        --arg model "$AI_MODEL" \
+        --arg language "$MESSAGE_LANGUAGE" \
+        --arg diffStat "$diff_stat_output" \
+        --arg rejected "${suggestion:+$previous_message}" \
+        --arg suggestion "${previous_message:+$suggestion}" '
+        {
+            diff: .,
+            diff_stat: $diffStat,
+            model: $model,
+            language: $language,
+            rejected_message: $rejected,
+            suggestion: $suggestion
+        } | with_entries(select(.value != ""))')
+
+    set_cache_key "$relay_url" "$request_json"
+    if use_cached_message; then
+        return
+    fi
+    log_verbose "Sending request to relay: " "$relay_url"
+
+    post_with_retries "$SCRIPT_URL" "$relay_url" "$request_json" "$RELAY_TOKEN" 90
+
+    suggestion=""
+
+    if [ "$http_status" = "000" ]; then
+        printf "${RED}Failed to connect to %s.${NC}\n" "$SCRIPT_URL"
+        exit 1
+    fi
+    if [ "$http_status" != "200" ]; then
+        message=$(printf '%s' "$response_body" | jq -r '.message // empty' 2>/dev/null)
+        if [ -z "$message" ]; then
+            message="Relay request failed with HTTP status $http_status"
+        fi
+        printf "${RED}%s${NC}\n" "$message"
+        exit 1
+    fi
+
+    message=$(printf '%s' "$response_body" | jq -r '.message // empty' | tr '\n' ' ')
+    answered_model=$(printf '%s' "$response_body" | jq -r '.model // empty' 2>/dev/null)
+    if [ -n "$answered_model" ]; then
+        printf "Generated with %s.\n" "$answered_model"
+    fi
+    log_verbose "Commit message received from relay"
+
+    previous_message="$message"
+    write_cached_message
 }
 
 commit_with_message() {
@@ -523,11 +1061,15 @@ main() {
         exit 0
     fi
 
-    if ! configure_openrouter; then
+    configure_retries
+
+    if [ "$USE_RELAY" = true ]; then
+        configure_relay
+    elif ! configure_provider; then
         setup_config || exit 1
         load_config
-        if ! configure_openrouter; then
-            printf "${RED}No OpenRouter API key found.${NC}\n"
+        if ! configure_provider; then
+            printf "${RED}No %s API key found.${NC}\n" "$PROVIDER_NAME"
             exit 1
         fi
     fi
@@ -535,6 +1077,7 @@ main() {
     while true; do
         log_verbose "Starting new iteration of main loop"
         get_commit_message
+        read_cache=false
 
         if [ -z "$message" ]; then
             log_verbose "Error: Empty message received from AI service"
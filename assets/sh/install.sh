@@ -1,10 +1,63 @@
 #!/bin/bash
 
+SCRIPT_URL="http://localhost"
+VERIFY=false
+PUBLIC_KEY_FILE="${COMMIT_PUBLIC_KEY:-}"
+COMMIT_ARGS=()
+TEMP_DIR=""
+
+# The server renders the template lines below; until then they are comments.
+#{{ assign "SCRIPT_URL" .Domain }}
+
+cleanup_temp_dir() {
+    if [ -n "$TEMP_DIR" ]; then
+        rm -rf "$TEMP_DIR"
+        TEMP_DIR=""
+    fi
+}
+
+trap cleanup_temp_dir EXIT
+trap 'cleanup_temp_dir; exit 1' HUP INT TERM
+
 command_exists() {
     command -v "$1" >/dev/null 2>&1
 }
 
+parse_arguments() {
+    while [[ $# -gt 0 ]]; do
+        case $1 in
+            --verify)
+                VERIFY=true
+                shift
+                ;;
+            --public-key)
+                if [ $# -lt 2 ] || [ -z "$2" ]; then
+                    echo "--public-key requires a file."
+                    exit 2
+                fi
+                PUBLIC_KEY_FILE=$2
+                shift 2
+                ;;
+            --)
+                shift
+                COMMIT_ARGS=("$@")
+                break
+                ;;
+            *)
+                echo "Invalid option: $1"
+                echo "Usage: install.sh [--verify [--public-key FILE] [-- commit.sh options]]"
+                exit 2
+                ;;
+        esac
+    done
+}
+
+parse_arguments "$@"
+
 commands=("jq" "git" "curl" "tail" "sed" "tr" "wc")
+if [ "$VERIFY" = true ]; then
+    commands+=("openssl")
+fi
 
 add_package() {
     local candidate="$1"
@@ -73,3 +126,38 @@ if [[ ${#missing_commands[@]} -gt 0 ]]; then
 fi
 
 echo "All required commands are installed."
+
+# Download commit.sh, check its detached ed25519 signature, then run it.
+run_verified_commit() {
+    local public_key="$PUBLIC_KEY_FILE"
+
+    TEMP_DIR=$(mktemp -d "${TMPDIR:-/tmp}/commit-install.XXXXXX") || exit 1
+    if ! curl -fsSL "$SCRIPT_URL/commit.sh" -o "$TEMP_DIR/commit.sh" ||
+        ! curl -fsSL "$SCRIPT_URL/commit.sh.sig" -o "$TEMP_DIR/commit.sh.sig"; then
+        echo "Failed to download the commit script and its signature from $SCRIPT_URL."
+        exit 1
+    fi
+
+    if [ -z "$public_key" ]; then
+        echo "Warning: no public key pinned, trusting $SCRIPT_URL/pubkey. Set COMMIT_PUBLIC_KEY to pin it."
+        public_key="$TEMP_DIR/pubkey.pem"
+        if ! curl -fsSL "$SCRIPT_URL/pubkey" -o "$public_key"; then
+            echo "Failed to download the public key from $SCRIPT_URL/pubkey."
+            exit 1
+        fi
+    fi
+
+    if ! openssl pkeyutl -verify -pubin -inkey "$public_key" -rawin \
+        -in "$TEMP_DIR/commit.sh" -sigfile "$TEMP_DIR/commit.sh.sig" >/dev/null 2>&1; then
+        echo "Signature verification failed for $SCRIPT_URL/commit.sh."
+        exit 1
+    fi
+    echo "Verified the commit script signature."
+
+    bash "$TEMP_DIR/commit.sh" "${COMMIT_ARGS[@]}"
+    exit $?
+}
+
+if [ "$VERIFY" = true ]; then
+    run_verified_commit
+fi
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"maps"
+	"path"
+	"slices"
+	texttemplate "text/template"
+
+	"github.com/wajeht/commit/assets"
+)
+
+var (
+	pageFiles = map[string]string{
+		"index.html":   "templates/index.html",
+		"install.html": "templates/install.html",
+		"doc.html":     "templates/doc.html",
+		"error.html":   "templates/error.html",
+	}
+	scriptFiles = map[string]string{
+		"commit.sh":  "sh/commit.sh",
+		"install.sh": "sh/install.sh",
+	}
+)
+
+// siteAssets provides the pages, scripts and static files being served. main
+// replaces it with a disk-backed source when ASSETS_DIR is set.
+var siteAssets = mustAssetSource(assets.Embeddedfiles)
+
+// assetSource serves templates and static files from fsys. By default every
+// template is parsed and every checksum computed once, up front. With reload
+// set, as for ASSETS_DIR in development, files are read and parsed again on
+// every use so edits show up without a rebuild.
+type assetSource struct {
+	fsys         fs.FS
+	reload       bool
+	pages        map[string]*htmltemplate.Template
+	scripts      map[string]*texttemplate.Template
+	sums         map[string]string
+	etags        map[string]string
+	systemPrompt string
+}
+
+func mustAssetSource(fsys fs.FS) *assetSource {
+	source, err := newAssetSource(fsys, false)
+	if err != nil {
+		panic(err)
+	}
+	return source
+}
+
+// newAssetSource checks that every template in fsys parses, and caches the
+// results unless reload is set.
+func newAssetSource(fsys fs.FS, reload bool) (*assetSource, error) {
+	source := &assetSource{fsys: fsys, reload: reload}
+	if err := source.check(); err != nil {
+		return nil, err
+	}
+	if reload {
+		return source, nil
+	}
+
+	source.pages = make(map[string]*htmltemplate.Template, len(pageFiles))
+	for name := range pageFiles {
+		source.pages[name], _ = source.parsePage(name)
+	}
+	source.scripts = make(map[string]*texttemplate.Template, len(scriptFiles))
+	source.sums = make(map[string]string, len(scriptFiles))
+	for name, file := range scriptFiles {
+		source.scripts[name], _ = source.parseScript(name)
+		source.sums[name], _ = source.fileSHA256(file)
+	}
+
+	source.systemPrompt, _ = source.parsePrompt()
+
+	etags, err := source.staticETags()
+	if err != nil {
+		return nil, err
+	}
+	source.etags = etags
+	return source, nil
+}
+
+// check parses every page and script template and renders each script, so a
+// broken asset is reported before a user request runs into it.
+func (a *assetSource) check() error {
+	var errs []error
+
+	for _, name := range slices.Sorted(maps.Keys(pageFiles)) {
+		if _, err := a.parsePage(name); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+		}
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(scriptFiles)) {
+		tmpl, err := a.parseScript(name)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+			continue
+		}
+
+		var script bytes.Buffer
+		if err := tmpl.Execute(&script, scriptData{Domain: "http://localhost", Version: version, Brand: defaultBranding}); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+		} else if script.Len() == 0 {
+			errs = append(errs, fmt.Errorf("%s: rendered script is empty", name))
+		}
+	}
+
+	if _, err := a.parsePrompt(); err != nil {
+		errs = append(errs, err)
+	}
+
+	return errors.Join(errs...)
+}
+
+func (a *assetSource) parsePage(name string) (*htmltemplate.Template, error) {
+	file, ok := pageFiles[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown page %q", name)
+	}
+	return htmltemplate.ParseFS(a.fsys, "templates/base.html", file)
+}
+
+// parseScript parses a shell script template. Actions start with "#{{" so the
+// unrendered script stays valid bash in which every action is a comment.
+func (a *assetSource) parseScript(name string) (*texttemplate.Template, error) {
+	file, ok := scriptFiles[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown script %q", name)
+	}
+	return texttemplate.New(path.Base(file)).
+		Delims("#{{", "}}").
+		Funcs(texttemplate.FuncMap{"assign": shellAssign, "quote": shellQuote}).
+		ParseFS(a.fsys, file)
+}
+
+// page returns the page template, e.g. "index.html", which is executed as
+// "base.html".
+func (a *assetSource) page(name string) (*htmltemplate.Template, error) {
+	if a.reload {
+		return a.parsePage(name)
+	}
+	tmpl, ok := a.pages[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown page %q", name)
+	}
+	return tmpl, nil
+}
+
+// script returns the template of a served script, "commit.sh" or "install.sh".
+func (a *assetSource) script(name string) (*texttemplate.Template, error) {
+	if a.reload {
+		return a.parseScript(name)
+	}
+	tmpl, ok := a.scripts[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown script %q", name)
+	}
+	return tmpl, nil
+}
+
+// scriptSHA256 returns the hex SHA-256 of the unrendered script template.
+func (a *assetSource) scriptSHA256(name string) (string, error) {
+	if !a.reload {
+		if sum, ok := a.sums[name]; ok {
+			return sum, nil
+		}
+	}
+	file, ok := scriptFiles[name]
+	if !ok {
+		return "", fmt.Errorf("unknown script %q", name)
+	}
+	return a.fileSHA256(file)
+}
+
+func (a *assetSource) fileSHA256(name string) (string, error) {
+	content, err := fs.ReadFile(a.fsys, name)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// prompt returns the system prompt from commit.sh, so generating a message on
+// the server uses the same instructions as the script.
+func (a *assetSource) prompt() (string, error) {
+	if !a.reload {
+		return a.systemPrompt, nil
+	}
+	return a.parsePrompt()
+}
+
+// parsePrompt extracts the PROMPT heredoc from commit.sh.
+func (a *assetSource) parsePrompt() (string, error) {
+	const start, end = "read -r -d '' PROMPT <<'EOF'\n", "\nEOF\n"
+
+	script, err := a.readFile(scriptFiles["commit.sh"])
+	if err != nil {
+		return "", err
+	}
+	_, rest, ok := bytes.Cut(script, []byte(start))
+	if !ok {
+		return "", errors.New("commit.sh: PROMPT heredoc not found")
+	}
+	prompt, _, ok := bytes.Cut(rest, []byte(end))
+	if !ok {
+		return "", errors.New("commit.sh: PROMPT heredoc is not terminated")
+	}
+	return string(prompt), nil
+}
+
+func (a *assetSource) readFile(name string) ([]byte, error) {
+	return fs.ReadFile(a.fsys, name)
+}
+
+// staticETag returns the strong ETag of a file under static/.
+func (a *assetSource) staticETag(name string) (string, bool) {
+	if !a.reload {
+		etag, ok := a.etags[name]
+		return etag, ok
+	}
+	content, err := a.readFile(name)
+	if err != nil {
+		return "", false
+	}
+	return strongETag(content), true
+}
+
+func (a *assetSource) staticETags() (map[string]string, error) {
+	etags := make(map[string]string)
+	err := fs.WalkDir(a.fsys, "static", func(name string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		content, err := a.readFile(name)
+		if err != nil {
+			return err
+		}
+		etags[name] = strongETag(content)
+		return nil
+	})
+	return etags, err
+}
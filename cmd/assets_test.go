@@ -0,0 +1,122 @@
+package main
+
+import (
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/wajeht/commit/assets"
+)
+
+// copyAssets copies the embedded assets into a temporary directory.
+func copyAssets(t *testing.T) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	err := fs.WalkDir(assets.Embeddedfiles, ".", func(name string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dir, name)
+		if entry.IsDir() {
+			return os.MkdirAll(target, 0o755)
+		}
+		content, err := assets.Embeddedfiles.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(target, content, 0o644)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func replaceInFile(t *testing.T, name, old, new string) {
+	t.Helper()
+
+	content, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(content), old) {
+		t.Fatalf("%s does not contain %q", name, old)
+	}
+	if err := os.WriteFile(name, []byte(strings.Replace(string(content), old, new, 1)), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDiskAssetsReloadOnEveryRequest(t *testing.T) {
+	dir := copyAssets(t)
+	source, err := newAssetSource(os.DirFS(dir), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	embedded := siteAssets
+	defer func() { siteAssets = embedded }()
+	siteAssets = source
+
+	handler := newTestApp().routes()
+	get := func(path, userAgent string) string {
+		req := httptest.NewRequest(http.MethodGet, "http://commit.jaw.dev"+path, nil)
+		req.Header.Set("User-Agent", userAgent)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr.Body.String()
+	}
+
+	replaceInFile(t, filepath.Join(dir, "templates/index.html"), "<h2>Basic Usage</h2>", "<h2>Edited Usage</h2>")
+	replaceInFile(t, filepath.Join(dir, "sh/commit.sh"), "#!/bin/bash", "#!/bin/bash\n# edited")
+	replaceInFile(t, filepath.Join(dir, "static/robots.txt"), "Disallow: /", "Disallow: /edited")
+
+	if body := get("/", "Mozilla/5.0"); !strings.Contains(body, "<h2>Edited Usage</h2>") {
+		t.Error("edited index.html was not served")
+	}
+	if body := get("/commit.sh", "curl/8.0.0"); !strings.Contains(body, "# edited") {
+		t.Error("edited commit.sh was not served")
+	}
+	if body := get("/robots.txt", "curl/8.0.0"); !strings.Contains(body, "Disallow: /edited") {
+		t.Error("edited robots.txt was not served")
+	}
+
+	sum, err := source.scriptSHA256("commit.sh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum == embedded.sums["commit.sh"] {
+		t.Error("scriptSHA256 did not change after editing commit.sh")
+	}
+	pinned := "/v/" + publishedScriptSHA256(t, handler, "") + "/commit.sh"
+	if body := get(pinned, "curl/8.0.0"); !strings.Contains(body, "# edited") {
+		t.Error("edited commit.sh is not served at its pinned URL")
+	}
+}
+
+func TestDiskAssetsReportBrokenTemplates(t *testing.T) {
+	dir := copyAssets(t)
+	replaceInFile(t, filepath.Join(dir, "templates/index.html"), "{{end}}", "{{end")
+
+	if _, err := newAssetSource(os.DirFS(dir), true); err == nil || !strings.Contains(err.Error(), "index.html") {
+		t.Errorf("newAssetSource() error = %v, want an index.html error", err)
+	}
+}
+
+func TestEmbeddedAssetsAreParsedOnce(t *testing.T) {
+	first, err := siteAssets.page("index.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := siteAssets.page("index.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first != second {
+		t.Error("embedded page template was parsed again")
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// branding lets a self-hosted instance rename the product and point the
+// script at its own OpenAI-compatible provider.
+type branding struct {
+	Name         string
+	Icon         string
+	Footer       string
+	SupportURL   string
+	SupportLabel string
+	DefaultModel string
+	ProviderName string
+	ProviderURL  string
+	ModelsURL    string
+	// AnalyticsScriptURL and AnalyticsWebsiteID add an Umami-compatible
+	// analytics script to the pages. Analytics is off when the URL is empty.
+	AnalyticsScriptURL string
+	AnalyticsWebsiteID string
+}
+
+var defaultBranding = branding{
+	Name:         "Commit",
+	Icon:         "🤖",
+	SupportURL:   "https://github.com/wajeht/commit",
+	SupportLabel: "Github",
+	DefaultModel: "openrouter/free",
+	ProviderName: "OpenRouter",
+	ProviderURL:  "https://openrouter.ai/api/v1",
+	ModelsURL:    "https://openrouter.ai/models",
+
+	AnalyticsScriptURL: "https://umami.jaw.dev/script.js",
+	AnalyticsWebsiteID: "523275d2-6964-4ccd-a40f-d6bec6b12c77",
+}
+
+// brand returns the configured branding, or defaultBranding when none is
+// configured.
+func (app *application) brand() branding {
+	if app.config.brand == (branding{}) {
+		return defaultBranding
+	}
+	return app.config.brand
+}
+
+// APIURL is the chat completions endpoint commit.sh sends requests to.
+func (b branding) APIURL() string {
+	if b.ProviderURL == "" {
+		return ""
+	}
+	return strings.TrimSuffix(b.ProviderURL, "/") + "/chat/completions"
+}
+
+// analyticsOrigin is the origin the analytics script reports to, which the
+// Content-Security-Policy allows in connect-src. It is empty when analytics is
+// off.
+func (b branding) analyticsOrigin() string {
+	if b.AnalyticsScriptURL == "" {
+		return ""
+	}
+	u, err := url.Parse(b.AnalyticsScriptURL)
+	if err != nil {
+		return ""
+	}
+	return u.Scheme + "://" + u.Host
+}
+
+func (b branding) validate() error {
+	var errs []error
+
+	for _, required := range []struct {
+		name  string
+		value string
+	}{
+		{"BRAND_NAME", b.Name},
+		{"DEFAULT_MODEL", b.DefaultModel},
+		{"PROVIDER_NAME", b.ProviderName},
+		{"PROVIDER_BASE_URL", b.ProviderURL},
+	} {
+		if required.value == "" {
+			errs = append(errs, fmt.Errorf("%s must not be empty", required.name))
+		}
+	}
+
+	for _, u := range []struct {
+		name  string
+		value string
+	}{
+		{"BRAND_SUPPORT_URL", b.SupportURL},
+		{"PROVIDER_BASE_URL", b.ProviderURL},
+		{"PROVIDER_MODELS_URL", b.ModelsURL},
+		{"ANALYTICS_SCRIPT_URL", b.AnalyticsScriptURL},
+	} {
+		if u.value == "" {
+			continue
+		}
+		parsed, err := url.Parse(u.value)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			errs = append(errs, fmt.Errorf("%s: %q must be an http or https URL", u.name, u.value))
+		}
+	}
+	if b.DefaultModel != "" && (len(b.DefaultModel) > maxPresetModelLen || !modelIDPattern.MatchString(b.DefaultModel)) {
+		errs = append(errs, fmt.Errorf("DEFAULT_MODEL: %q must be a model ID without whitespace", b.DefaultModel))
+	}
+	if b.AnalyticsWebsiteID != "" && b.AnalyticsScriptURL == "" {
+		errs = append(errs, errors.New("ANALYTICS_WEBSITE_ID requires ANALYTICS_SCRIPT_URL"))
+	}
+	if strings.ContainsAny(b.Name+b.ProviderName, "\r\n") {
+		errs = append(errs, errors.New("BRAND_NAME and PROVIDER_NAME must be a single line"))
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+var testBranding = branding{
+	Name:         "Acme Commit",
+	Footer:       "Run by Acme IT",
+	SupportURL:   "https://intranet.example.com/help",
+	SupportLabel: "Help desk",
+	DefaultModel: "llama3.1:8b",
+	ProviderName: "Acme LLM",
+	ProviderURL:  "https://llm.example.com/v1/",
+}
+
+func TestBrandingValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(*branding)
+		want   string
+	}{
+		{"default", func(*branding) {}, ""},
+		{"no models url", func(b *branding) { b.ModelsURL = "" }, ""},
+		{"empty name", func(b *branding) { b.Name = "" }, "BRAND_NAME must not be empty"},
+		{"empty provider url", func(b *branding) { b.ProviderURL = "" }, "PROVIDER_BASE_URL must not be empty"},
+		{"provider url scheme", func(b *branding) { b.ProviderURL = "ftp://llm.example.com" }, "PROVIDER_BASE_URL"},
+		{"support url", func(b *branding) { b.SupportURL = "javascript:alert(1)" }, "BRAND_SUPPORT_URL"},
+		{"model with space", func(b *branding) { b.DefaultModel = "gpt 4" }, "DEFAULT_MODEL"},
+		{"analytics", func(b *branding) { b.AnalyticsScriptURL = "https://stats.example.com/script.js" }, ""},
+		{"analytics url scheme", func(b *branding) { b.AnalyticsScriptURL = "javascript:alert(1)" }, "ANALYTICS_SCRIPT_URL"},
+		{"analytics website without url", func(b *branding) { b.AnalyticsScriptURL = "" }, "ANALYTICS_WEBSITE_ID requires ANALYTICS_SCRIPT_URL"},
+		{"multi-line name", func(b *branding) { b.Name = "Commit\nrm -rf /" }, "single line"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := defaultBranding
+			tt.modify(&b)
+
+			err := b.validate()
+			if tt.want == "" {
+				if err != nil {
+					t.Errorf("validate() = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("validate() = %v, want error containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestBrandingAPIURL(t *testing.T) {
+	if got, want := defaultBranding.APIURL(), "https://openrouter.ai/api/v1/chat/completions"; got != want {
+		t.Errorf("APIURL() = %q, want %q", got, want)
+	}
+	if got, want := testBranding.APIURL(), "https://llm.example.com/v1/chat/completions"; got != want {
+		t.Errorf("APIURL() = %q, want %q", got, want)
+	}
+}
+
+func TestHandleHomeBranding(t *testing.T) {
+	app := newTestApp()
+	app.config.brand = testBranding
+	req := httptest.NewRequest(http.MethodGet, "http://commit.example.com/", nil)
+	req.Header.Set("User-Agent", "Mozilla/5.0")
+	rr := httptest.NewRecorder()
+
+	app.handleHome(rr, req)
+
+	body := rr.Body.String()
+	for _, want := range []string{
+		"<title>Acme Commit</title>",
+		"<h1>Acme Commit</h1>",
+		"Run by Acme IT",
+		`<a href="https://intranet.example.com/help"`,
+		"Help desk",
+		"<code>llama3.1:8b</code>",
+		"Acme LLM API key",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("body does not contain %q", want)
+		}
+	}
+	for _, unwanted := range []string{"OpenRouter", "openrouter", "wajeht"} {
+		if strings.Contains(body, unwanted) {
+			t.Errorf("body contains %q", unwanted)
+		}
+	}
+}
+
+func TestCommitScriptBranding(t *testing.T) {
+	var script bytes.Buffer
+	if err := renderScript(&script, "commit.sh", scriptData{Domain: "https://commit.example.com", Brand: testBranding}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{
+		"PRODUCT_NAME='Acme Commit'",
+		"PROVIDER_NAME='Acme LLM'",
+		"API_URL='https://llm.example.com/v1/chat/completions'",
+		"DEFAULT_MODEL='llama3.1:8b'",
+	} {
+		if !strings.Contains(script.String(), want) {
+			t.Errorf("script does not contain %q", want)
+		}
+	}
+
+	if output, err := exec.Command("bash", "-n", "-c", script.String()).CombinedOutput(); err != nil {
+		t.Fatalf("bash -n failed: %v\n%s", err, output)
+	}
+
+	cmd := exec.Command("bash", "-s", "--", "--help")
+	cmd.Stdin = bytes.NewReader(script.Bytes())
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("commit script help failed: %v\n%s", err, output)
+	}
+	if !strings.Contains(string(output), "Default model: llama3.1:8b") {
+		t.Errorf("help output does not show the default model:\n%s", output)
+	}
+	if strings.Contains(string(output), "openrouter.ai") {
+		t.Errorf("help output links to OpenRouter:\n%s", output)
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const commandUsage = `Commands:
+  token create -user NAME [-daily-requests N] [-daily-tokens N]
+        issue a bearer token for the generate endpoint
+  token revoke ID
+        revoke a token
+  token list
+        list tokens with today's usage
+`
+
+// runCommand runs an admin command given after the flags, e.g.
+// "commit -config commit.toml token list".
+func runCommand(cfg config, args []string, w io.Writer) error {
+	switch args[0] {
+	case "token":
+		return runTokenCommand(cfg, args[1:], w)
+	default:
+		return fmt.Errorf("unknown command %q\n\n%s", args[0], commandUsage)
+	}
+}
+
+func runTokenCommand(cfg config, args []string, w io.Writer) error {
+	if len(args) == 0 {
+		return fmt.Errorf("missing token command\n\n%s", commandUsage)
+	}
+	if cfg.authDB == "" {
+		return errors.New("AUTH_DB must be set to manage tokens")
+	}
+
+	store, err := openTokenStore(cfg.authDB)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	switch args[0] {
+	case "create":
+		return createToken(store, args[1:], w)
+	case "revoke":
+		if len(args) != 2 {
+			return errors.New("usage: token revoke ID")
+		}
+		if err := store.revoke(args[1], time.Now()); err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "Revoked %s\n", args[1])
+		return nil
+	case "list":
+		if len(args) != 1 {
+			return errors.New("usage: token list")
+		}
+		return listTokens(store, cfg.quota, w)
+	default:
+		return fmt.Errorf("unknown token command %q\n\n%s", args[0], commandUsage)
+	}
+}
+
+func createToken(store *tokenStore, args []string, w io.Writer) error {
+	flags := flag.NewFlagSet("token create", flag.ContinueOnError)
+	flags.SetOutput(w)
+	user := flags.String("user", "", "name of the person or service the token is for")
+	dailyRequests := flags.String("daily-requests", "", "requests per UTC day, 0 for unlimited (default QUOTA_DAILY_REQUESTS)")
+	dailyTokens := flags.String("daily-tokens", "", "model tokens per UTC day, 0 for unlimited (default QUOTA_DAILY_TOKENS)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() > 0 {
+		return fmt.Errorf("unexpected argument %q", flags.Arg(0))
+	}
+
+	*user = strings.TrimSpace(*user)
+	if *user == "" || strings.ContainsAny(*user, "\r\n\t") {
+		return errors.New("-user must be a single-line name")
+	}
+	requests, err := parseLimit("-daily-requests", *dailyRequests)
+	if err != nil {
+		return err
+	}
+	tokens, err := parseLimit("-daily-tokens", *dailyTokens)
+	if err != nil {
+		return err
+	}
+
+	token, record, err := store.create(*user, requests, tokens, time.Now())
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "Created %s for %s. Store the token now, it cannot be shown again:\n%s\n", record.id, record.user, token)
+	return nil
+}
+
+// parseLimit parses an optional quota flag. An empty value keeps the server
+// default.
+func parseLimit(name, value string) (*int64, error) {
+	if value == "" {
+		return nil, nil
+	}
+	limit, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || limit < 0 {
+		return nil, fmt.Errorf("%s: %q must be a non-negative integer", name, value)
+	}
+	return &limit, nil
+}
+
+func listTokens(store *tokenStore, defaults quota, w io.Writer) error {
+	day := usageDay(time.Now())
+	records, usages, err := store.list(defaults, day)
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tUSER\tCREATED\tSTATUS\tREQUESTS TODAY\tTOKENS TODAY")
+	for i, record := range records {
+		status := "active"
+		if !record.revokedAt.IsZero() {
+			status = "revoked " + record.revokedAt.Format(time.DateOnly)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n",
+			record.id, record.user, record.createdAt.Format(time.DateOnly), status,
+			formatUsage(usages[i].requests, record.quota.requests),
+			formatUsage(usages[i].tokens, record.quota.tokens))
+	}
+	return tw.Flush()
+}
+
+func formatUsage(used, limit int64) string {
+	if limit == 0 {
+		return strconv.FormatInt(used, 10) + "/unlimited"
+	}
+	return strconv.FormatInt(used, 10) + "/" + strconv.FormatInt(limit, 10)
+}
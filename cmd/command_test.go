@@ -0,0 +1,69 @@
+package main
+
+import (
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestTokenCommands(t *testing.T) {
+	cfg := config{authDB: filepath.Join(t.TempDir(), "commit.db"), quota: quota{requests: 200, tokens: 0}}
+	run := func(args ...string) (string, error) {
+		var out strings.Builder
+		err := runCommand(cfg, args, &out)
+		return out.String(), err
+	}
+
+	output, err := run("token", "create", "-user", "alice", "-daily-tokens", "5000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := regexp.MustCompile(`tok_[0-9a-f]{12}`).FindString(output)
+	if id == "" || !regexp.MustCompile(`(?m)^cmt_[A-Za-z0-9_-]{43}$`).MatchString(output) {
+		t.Fatalf("create output = %q, want an ID and a token", output)
+	}
+
+	output, err = run("token", "list")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"USER", id, "alice", "active", "0/200", "0/5000"} {
+		if !strings.Contains(output, want) {
+			t.Errorf("list output does not contain %q:\n%s", want, output)
+		}
+	}
+
+	if _, err := run("token", "revoke", id); err != nil {
+		t.Fatal(err)
+	}
+	if output, _ := run("token", "list"); !strings.Contains(output, "revoked") {
+		t.Errorf("list output after revoke:\n%s", output)
+	}
+}
+
+func TestTokenCommandErrors(t *testing.T) {
+	cfg := config{authDB: filepath.Join(t.TempDir(), "commit.db")}
+
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"serve"}, "unknown command"},
+		{[]string{"token"}, "missing token command"},
+		{[]string{"token", "rotate"}, "unknown token command"},
+		{[]string{"token", "create"}, "-user must be"},
+		{[]string{"token", "create", "-user", "a", "-daily-requests", "-1"}, "non-negative integer"},
+		{[]string{"token", "revoke", "tok_missing"}, "no active token"},
+	}
+	for _, tt := range tests {
+		err := runCommand(cfg, tt.args, &strings.Builder{})
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%v: error = %v, want %q", tt.args, err, tt.want)
+		}
+	}
+
+	if err := runCommand(config{}, []string{"token", "list"}, &strings.Builder{}); err == nil || !strings.Contains(err.Error(), "AUTH_DB") {
+		t.Errorf("without AUTH_DB: error = %v", err)
+	}
+}
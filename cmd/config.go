@@ -0,0 +1,210 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// loadConfig reads the configuration from the environment and the config
+// file loaded into env, and reports every invalid setting at once.
+func loadConfig() (config, error) {
+	var errs []error
+
+	signingKey, err := parseSigningKey(GetSecret("SIGNING_KEY", ""))
+	if err != nil {
+		errs = append(errs, fmt.Errorf("SIGNING_KEY: %w", err))
+	}
+
+	trustedProxies, err := parseTrustedProxies(GetString("TRUSTED_PROXIES", ""))
+	if err != nil {
+		errs = append(errs, fmt.Errorf("TRUSTED_PROXIES: %w", err))
+	}
+
+	publicURL, err := parsePublicURL(GetString("PUBLIC_URL", ""))
+	if err != nil {
+		errs = append(errs, fmt.Errorf("PUBLIC_URL: %w", err))
+	}
+
+	socketMode, err := parseSocketMode(GetString("APP_SOCKET_MODE", "0660"))
+	if err != nil {
+		errs = append(errs, fmt.Errorf("APP_SOCKET_MODE: %w", err))
+	}
+
+	cfg := config{
+		appEnv:         GetString("APP_ENV", "production"),
+		appPort:        GetInt("APP_PORT", 80),
+		listen:         GetString("APP_LISTEN", ""),
+		socketMode:     socketMode,
+		socketGroup:    GetString("APP_SOCKET_GROUP", ""),
+		signingKey:     signingKey,
+		metricsAddr:    GetString("METRICS_ADDR", ""),
+		metricsToken:   GetSecret("METRICS_TOKEN", ""),
+		trustedProxies: trustedProxies,
+		publicURL:      publicURL,
+		allowedHosts:   GetList("ALLOWED_HOSTS", nil),
+		assetsDir:      GetString("ASSETS_DIR", ""),
+		brand: branding{
+			Name:         GetString("BRAND_NAME", defaultBranding.Name),
+			Icon:         GetString("BRAND_ICON", defaultBranding.Icon),
+			Footer:       GetString("BRAND_FOOTER", defaultBranding.Footer),
+			SupportURL:   GetString("BRAND_SUPPORT_URL", defaultBranding.SupportURL),
+			SupportLabel: GetString("BRAND_SUPPORT_LABEL", defaultBranding.SupportLabel),
+			DefaultModel: GetString("DEFAULT_MODEL", defaultBranding.DefaultModel),
+			ProviderName: GetString("PROVIDER_NAME", defaultBranding.ProviderName),
+			ProviderURL:  GetString("PROVIDER_BASE_URL", defaultBranding.ProviderURL),
+			ModelsURL:    GetString("PROVIDER_MODELS_URL", defaultBranding.ModelsURL),
+
+			AnalyticsScriptURL: GetString("ANALYTICS_SCRIPT_URL", defaultBranding.AnalyticsScriptURL),
+			AnalyticsWebsiteID: GetString("ANALYTICS_WEBSITE_ID", defaultBranding.AnalyticsWebsiteID),
+		},
+		relayAPIKey:        GetSecret("RELAY_API_KEY", ""),
+		relayAllowedModels: GetList("RELAY_MODELS", nil),
+		relayTimeout:       GetDuration("RELAY_TIMEOUT", time.Minute),
+		authDB:             GetString("AUTH_DB", ""),
+		quota: quota{
+			requests: int64(GetInt("QUOTA_DAILY_REQUESTS", 200)),
+			tokens:   int64(GetInt("QUOTA_DAILY_TOKENS", 500000)),
+		},
+		auditLog:      GetString("AUDIT_LOG", ""),
+		auditLogDiffs: GetBool("AUDIT_LOG_DIFFS", false),
+		rateLimits: map[string]rateLimit{
+			"script": {
+				perMinute: GetInt("RATE_LIMIT_SCRIPT_PER_MINUTE", 60),
+				burst:     GetInt("RATE_LIMIT_SCRIPT_BURST", 20),
+			},
+			"relay": {
+				perMinute: GetInt("RATE_LIMIT_RELAY_PER_MINUTE", 10),
+				burst:     GetInt("RATE_LIMIT_RELAY_BURST", 5),
+			},
+		},
+		tlsCertFile:      GetString("TLS_CERT_FILE", ""),
+		tlsKeyFile:       GetString("TLS_KEY_FILE", ""),
+		httpRedirectPort: GetInt("HTTP_REDIRECT_PORT", 0),
+		acmeDomains:      GetList("ACME_DOMAINS", nil),
+		acmeEmail:        GetString("ACME_EMAIL", ""),
+		acmeCacheDir:     GetString("ACME_CACHE_DIR", "certs"),
+		acmeDirectoryURL: GetString("ACME_DIRECTORY_URL", ""),
+		acmeCARoot:       GetString("ACME_CA_ROOT", ""),
+
+		readHeaderTimeout: GetDuration("READ_HEADER_TIMEOUT", 5*time.Second),
+		readTimeout:       GetDuration("READ_TIMEOUT", 15*time.Second),
+		writeTimeout:      GetDuration("WRITE_TIMEOUT", 30*time.Second),
+		idleTimeout:       GetDuration("IDLE_TIMEOUT", 2*time.Minute),
+		maxHeaderBytes:    GetInt("MAX_HEADER_BYTES", 64<<10),
+		drainDelay:        GetDuration("SHUTDOWN_DRAIN_DELAY", 5*time.Second),
+		shutdownTimeout:   GetDuration("SHUTDOWN_TIMEOUT", 30*time.Second),
+	}
+
+	errs = append(errs, env.errs...)
+	errs = append(errs, cfg.Validate())
+	return cfg, errors.Join(errs...)
+}
+
+// Validate checks that the settings are usable together and returns all
+// problems joined into one error.
+func (cfg config) Validate() error {
+	var errs []error
+
+	if cfg.assetsDir != "" && cfg.appEnv != "development" {
+		errs = append(errs, errors.New("ASSETS_DIR is only supported with APP_ENV=development"))
+	}
+	if cfg.appPort < 0 || cfg.appPort > 65535 {
+		errs = append(errs, fmt.Errorf("APP_PORT: %d is not a valid port", cfg.appPort))
+	}
+	if path, ok := strings.CutPrefix(cfg.listen, "unix:"); ok {
+		if path == "" {
+			errs = append(errs, errors.New("APP_LISTEN: unix: requires a socket path"))
+		}
+	} else if cfg.listen != "" {
+		if _, _, err := net.SplitHostPort(cfg.listen); err != nil {
+			errs = append(errs, fmt.Errorf("APP_LISTEN: %q must be host:port or unix:PATH", cfg.listen))
+		}
+	}
+
+	for name, limit := range cfg.rateLimits {
+		if limit.perMinute < 0 || limit.burst < 0 {
+			errs = append(errs, fmt.Errorf("rate limit %q must not be negative", name))
+		}
+	}
+
+	for _, d := range []struct {
+		name  string
+		value time.Duration
+	}{
+		{"READ_HEADER_TIMEOUT", cfg.readHeaderTimeout},
+		{"READ_TIMEOUT", cfg.readTimeout},
+		{"WRITE_TIMEOUT", cfg.writeTimeout},
+		{"IDLE_TIMEOUT", cfg.idleTimeout},
+		{"SHUTDOWN_DRAIN_DELAY", cfg.drainDelay},
+		{"SHUTDOWN_TIMEOUT", cfg.shutdownTimeout},
+		{"RELAY_TIMEOUT", cfg.relayTimeout},
+	} {
+		if d.value < 0 {
+			errs = append(errs, fmt.Errorf("%s: %s must not be negative", d.name, d.value))
+		}
+	}
+	if cfg.maxHeaderBytes < 0 {
+		errs = append(errs, fmt.Errorf("MAX_HEADER_BYTES: %d must not be negative", cfg.maxHeaderBytes))
+	}
+
+	if err := cfg.brand.validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if cfg.relayAPIKey != "" && cfg.relayTimeout <= 0 {
+		errs = append(errs, errors.New("RELAY_TIMEOUT must be positive when RELAY_API_KEY is set"))
+	}
+	if cfg.quota.requests < 0 || cfg.quota.tokens < 0 {
+		errs = append(errs, errors.New("QUOTA_DAILY_REQUESTS and QUOTA_DAILY_TOKENS must not be negative"))
+	}
+	for _, model := range cfg.relayAllowedModels {
+		if len(model) > maxPresetModelLen || !modelIDPattern.MatchString(model) {
+			errs = append(errs, fmt.Errorf("RELAY_MODELS: %q is not a model ID", model))
+		}
+	}
+
+	tls := cfg.tlsCertFile != "" || len(cfg.acmeDomains) > 0
+	switch {
+	case cfg.tlsCertFile != "" && len(cfg.acmeDomains) > 0:
+		errs = append(errs, errors.New("TLS_CERT_FILE and ACME_DOMAINS cannot both be set"))
+	case cfg.tlsCertFile != "" && cfg.tlsKeyFile == "":
+		errs = append(errs, errors.New("TLS_CERT_FILE requires TLS_KEY_FILE"))
+	case cfg.tlsCertFile == "" && cfg.tlsKeyFile != "":
+		errs = append(errs, errors.New("TLS_KEY_FILE requires TLS_CERT_FILE"))
+	}
+	if cfg.httpRedirectPort != 0 {
+		switch {
+		case cfg.httpRedirectPort < 0 || cfg.httpRedirectPort > 65535:
+			errs = append(errs, fmt.Errorf("HTTP_REDIRECT_PORT: %d is not a valid port", cfg.httpRedirectPort))
+		case !tls:
+			errs = append(errs, errors.New("HTTP_REDIRECT_PORT requires TLS_CERT_FILE or ACME_DOMAINS"))
+		case cfg.httpRedirectPort == cfg.httpsPort():
+			errs = append(errs, errors.New("HTTP_REDIRECT_PORT must differ from the HTTPS port in APP_LISTEN or APP_PORT"))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// httpsPort returns the port the main listener serves on: the port in
+// APP_LISTEN, or APP_PORT. It is 0 for a Unix socket, whose public port is
+// up to the proxy in front of it.
+func (cfg config) httpsPort() int {
+	if strings.HasPrefix(cfg.listen, "unix:") {
+		return 0
+	}
+	if cfg.listen == "" {
+		return cfg.appPort
+	}
+	_, port, err := net.SplitHostPort(cfg.listen)
+	if err != nil {
+		return 0
+	}
+	number, err := net.LookupPort("tcp", port)
+	if err != nil {
+		return 0
+	}
+	return number
+}
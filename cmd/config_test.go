@@ -0,0 +1,100 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestLoadConfigReportsAllErrors(t *testing.T) {
+	defer func(saved *settings) { env = saved }(env)
+	env = newSettings(nil)
+	t.Setenv("APP_ENV", "staging")
+	t.Setenv("APP_PORT", "eighty")
+	t.Setenv("SIGNING_KEY", "not-base64!")
+	t.Setenv("TRUSTED_PROXIES", "nope")
+	t.Setenv("TLS_CERT_FILE", "cert.pem")
+
+	_, err := loadConfig()
+	if err == nil {
+		t.Fatal("loadConfig() error = nil")
+	}
+	for _, want := range []string{
+		"SIGNING_KEY:",
+		"TRUSTED_PROXIES:",
+		`APP_PORT: "eighty" is not an integer`,
+		"TLS_CERT_FILE requires TLS_KEY_FILE",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error does not contain %q:\n%v", want, err)
+		}
+	}
+}
+
+func TestLoadConfigDefaults(t *testing.T) {
+	defer func(saved *settings) { env = saved }(env)
+	env = newSettings(nil)
+	unsetEnv(t, "APP_ENV", "APP_PORT")
+
+	cfg, err := loadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.appEnv != "production" || cfg.appPort != 80 || cfg.acmeCacheDir != "certs" {
+		t.Errorf("unexpected defaults: %+v", cfg)
+	}
+	if got := cfg.rateLimits["script"]; got != (rateLimit{perMinute: 60, burst: 20}) {
+		t.Errorf("script rate limit = %+v", got)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	valid := config{appEnv: "production", appPort: 443, tlsCertFile: "cert.pem", tlsKeyFile: "key.pem", httpRedirectPort: 80, brand: defaultBranding}
+
+	tests := []struct {
+		name   string
+		modify func(*config)
+		want   string
+	}{
+		{"valid", func(*config) {}, ""},
+		{"port", func(c *config) { c.appPort = 70000 }, "APP_PORT"},
+		{"cert and acme", func(c *config) { c.acmeDomains = []string{"example.com"} }, "cannot both be set"},
+		{"key without cert", func(c *config) { c.tlsCertFile = "" }, "TLS_KEY_FILE requires TLS_CERT_FILE"},
+		{"redirect without tls", func(c *config) { c.tlsCertFile, c.tlsKeyFile = "", "" }, "HTTP_REDIRECT_PORT requires"},
+		{"redirect on app port", func(c *config) { c.httpRedirectPort = 443 }, "must differ from the HTTPS port"},
+		{"redirect with listen address", func(c *config) { c.appPort, c.listen = 80, ":8443" }, ""},
+		{"redirect on listen port", func(c *config) { c.listen, c.httpRedirectPort = "127.0.0.1:8080", 8080 }, "must differ from the HTTPS port"},
+		{"negative rate limit", func(c *config) { c.rateLimits = map[string]rateLimit{"script": {perMinute: -1}} }, "must not be negative"},
+		{"listen address", func(c *config) { c.listen = "commit.sock" }, "APP_LISTEN"},
+		{"unix socket without path", func(c *config) { c.listen = "unix:" }, "requires a socket path"},
+		{"empty brand name", func(c *config) { c.brand.Name = "" }, "BRAND_NAME must not be empty"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := valid
+			tt.modify(&cfg)
+
+			err := cfg.Validate()
+			if tt.want == "" {
+				if err != nil {
+					t.Errorf("Validate() = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Validate() = %v, want error containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+// unsetEnv unsets keys for the rest of the test, so defaults do not depend on
+// the environment the tests run in.
+func unsetEnv(t *testing.T, keys ...string) {
+	t.Helper()
+	for _, key := range keys {
+		t.Setenv(key, "")
+		os.Unsetenv(key)
+	}
+}
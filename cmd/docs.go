@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"strings"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/parser"
+
+	"github.com/wajeht/commit/docs"
+)
+
+// docsOrigin is the public instance the guides are written against. It is
+// replaced with the serving instance's own origin.
+const docsOrigin = "https://commit.jaw.dev"
+
+// docFiles holds the Markdown guides served under /docs/.
+var docFiles fs.FS = docs.Embeddedfiles
+
+// markdown renders GitHub-flavoured Markdown. Raw HTML in the source is
+// omitted.
+var markdown = goldmark.New(
+	goldmark.WithExtensions(extension.GFM),
+	goldmark.WithParserOptions(parser.WithAutoHeadingID()),
+)
+
+type docPageData struct {
+	Title string
+	Nonce string
+	Brand branding
+	HTML  template.HTML
+}
+
+type docResponse struct {
+	Page     string `json:"page"`
+	Title    string `json:"title"`
+	Markdown string `json:"markdown"`
+	HTML     string `json:"html"`
+}
+
+// readDoc returns the Markdown source of a guide, e.g. "recipe" for
+// docs/recipe.md, with links to the public instance pointing at domain.
+func readDoc(page, domain string) (string, error) {
+	if page == "" || strings.ContainsAny(page, `/\.`) {
+		return "", fs.ErrNotExist
+	}
+	content, err := fs.ReadFile(docFiles, page+".md")
+	if err != nil {
+		return "", err
+	}
+	return strings.ReplaceAll(string(content), docsOrigin, domain), nil
+}
+
+// docTitle returns the text of the first level-one heading, or fallback.
+func docTitle(source, fallback string) string {
+	for line := range strings.Lines(source) {
+		if title, ok := strings.CutPrefix(line, "# "); ok {
+			return strings.TrimSpace(title)
+		}
+	}
+	return fallback
+}
+
+func (app *application) handleDoc(w http.ResponseWriter, r *http.Request) {
+	page := strings.TrimSuffix(r.PathValue("page"), ".md")
+	source, err := readDoc(page, app.domain(r))
+	if errors.Is(err, fs.ErrNotExist) {
+		app.notFound(w, r)
+		return
+	}
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	varyNegotiated(w)
+	representation := negotiate(r, mediaHTML, mediaJSON, mediaText)
+
+	if representation == mediaText {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		if _, err := w.Write([]byte(source)); err != nil {
+			app.reportServerError(r, err)
+		}
+		return
+	}
+
+	var rendered bytes.Buffer
+	if err := markdown.Convert([]byte(source), &rendered); err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	title := docTitle(source, page)
+
+	if representation == mediaJSON {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		if err := json.NewEncoder(w).Encode(docResponse{
+			Page:     page,
+			Title:    title,
+			Markdown: source,
+			HTML:     rendered.String(),
+		}); err != nil {
+			app.reportServerError(r, err)
+		}
+		return
+	}
+
+	tmpl, err := siteAssets.page("doc.html")
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	var body bytes.Buffer
+	if err := tmpl.ExecuteTemplate(&body, "base.html", docPageData{
+		Title: title,
+		Nonce: cspNonce(r),
+		Brand: app.brand(),
+		HTML:  template.HTML(rendered.String()),
+	}); err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	if _, err := body.WriteTo(w); err != nil {
+		app.reportServerError(r, err)
+	}
+}
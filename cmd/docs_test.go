@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHandleDoc(t *testing.T) {
+	tests := []struct {
+		name        string
+		userAgent   string
+		accept      string
+		contentType string
+		want        []string
+	}{
+		{"browser", "Mozilla/5.0", "text/html", "text/html; charset=utf-8", []string{
+			"<title>🧑‍🍳 Recipe</title>",
+			`<h1 id="-recipe">🧑‍🍳 Recipe</h1>`,
+			`<code class="language-make">`,
+			"curl -fsSL http://commit.example.com/ | bash",
+		}},
+		{"curl", "curl/8.0.0", "*/*", "text/plain; charset=utf-8", []string{
+			"# 🧑‍🍳 Recipe",
+			"```make",
+			"curl -fsSL http://commit.example.com/ | bash",
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApp()
+			req := httptest.NewRequest(http.MethodGet, "http://commit.example.com/docs/recipe", nil)
+			req.Header.Set("User-Agent", tt.userAgent)
+			req.Header.Set("Accept", tt.accept)
+			rr := httptest.NewRecorder()
+
+			app.routes().ServeHTTP(rr, req)
+
+			if rr.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d", rr.Code, http.StatusOK)
+			}
+			if got := rr.Header().Get("Content-Type"); got != tt.contentType {
+				t.Errorf("Content-Type = %q, want %q", got, tt.contentType)
+			}
+			if got := rr.Header().Get("Vary"); got != "Accept, User-Agent" {
+				t.Errorf("Vary = %q, want Accept, User-Agent", got)
+			}
+			body := rr.Body.String()
+			for _, want := range tt.want {
+				if !strings.Contains(body, want) {
+					t.Errorf("body does not contain %q", want)
+				}
+			}
+			if strings.Contains(body, "commit.jaw.dev") {
+				t.Error("body still links to commit.jaw.dev")
+			}
+		})
+	}
+}
+
+func TestHandleDocJSON(t *testing.T) {
+	app := newTestApp()
+	app.config.publicURL = "https://commit.example.com"
+	req := httptest.NewRequest(http.MethodGet, "http://localhost/docs/manual-qa.md", nil)
+	req.Header.Set("Accept", "application/json")
+	rr := httptest.NewRecorder()
+
+	app.routes().ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rr.Code, http.StatusOK)
+	}
+	var doc docResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.Page != "manual-qa" || doc.Title == "" {
+		t.Errorf("page = %q, title = %q", doc.Page, doc.Title)
+	}
+	if !strings.Contains(doc.Markdown, "https://commit.example.com/install.sh") {
+		t.Error("markdown does not use the public URL")
+	}
+	if !strings.HasPrefix(doc.HTML, "<h1") {
+		t.Errorf("html = %.40q, want rendered Markdown", doc.HTML)
+	}
+}
+
+func TestHandleDocNotFound(t *testing.T) {
+	app := newTestApp()
+
+	for _, path := range []string{"/docs/missing", "/docs/..%2Fgo.mod", "/docs/efs.go", "/docs/"} {
+		req := httptest.NewRequest(http.MethodGet, "http://commit.example.com"+path, nil)
+		req.Header.Set("Accept", "application/json")
+		rr := httptest.NewRecorder()
+
+		app.routes().ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusNotFound {
+			t.Errorf("%s: status = %d, want %d", path, rr.Code, http.StatusNotFound)
+		}
+	}
+}
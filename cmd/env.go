@@ -1,29 +1,206 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
+	"io"
+	"maps"
 	"os"
+	"path/filepath"
+	"slices"
 	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
 )
 
-func GetString(key string, defaultValue string) string {
+// settings resolves configuration values from environment variables, falling
+// back to an optional config file. Invalid values are collected instead of
+// stopping at the first one, so startup can report them all at once.
+type settings struct {
+	file    map[string]string
+	values  map[string]string
+	secrets map[string]bool
+	errs    []error
+}
+
+// env backs GetString and the other Get functions. main loads the config
+// file into it before reading any setting.
+var env = newSettings(nil)
+
+func newSettings(file map[string]string) *settings {
+	return &settings{
+		file:    file,
+		values:  make(map[string]string),
+		secrets: make(map[string]bool),
+	}
+}
+
+func (s *settings) lookup(key string) (string, bool) {
 	value, exists := os.LookupEnv(key)
 	if !exists {
-		return defaultValue
+		value, exists = s.file[key]
+	}
+	return value, exists
+}
+
+// get returns the raw value for key and records the effective value for
+// --print-config.
+func (s *settings) get(key, defaultValue string) (string, bool) {
+	value, exists := s.lookup(key)
+	if !exists {
+		value = defaultValue
 	}
+	s.values[key] = value
+	return value, exists
+}
+
+func (s *settings) invalid(key, value, want string) {
+	s.errs = append(s.errs, fmt.Errorf("%s: %q is not %s", key, value, want))
+}
+
+func GetString(key string, defaultValue string) string {
+	value, _ := env.get(key, defaultValue)
 	return value
 }
 
+// GetSecret is GetString for values that --print-config must not show.
+func GetSecret(key string, defaultValue string) string {
+	env.secrets[key] = true
+	return GetString(key, defaultValue)
+}
+
 func GetInt(key string, defaultValue int) int {
-	value, exists := os.LookupEnv(key)
+	value, exists := env.get(key, strconv.Itoa(defaultValue))
 	if !exists {
 		return defaultValue
 	}
 
 	intValue, err := strconv.Atoi(value)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "warning: invalid value for %s: %q, using default %d\n", key, value, defaultValue)
+		env.invalid(key, value, "an integer")
 		return defaultValue
 	}
 	return intValue
 }
+
+func GetBool(key string, defaultValue bool) bool {
+	value, exists := env.get(key, strconv.FormatBool(defaultValue))
+	if !exists {
+		return defaultValue
+	}
+
+	boolValue, err := strconv.ParseBool(value)
+	if err != nil {
+		env.invalid(key, value, "a boolean")
+		return defaultValue
+	}
+	return boolValue
+}
+
+// GetDuration parses values such as "30s" or "1m30s".
+func GetDuration(key string, defaultValue time.Duration) time.Duration {
+	value, exists := env.get(key, defaultValue.String())
+	if !exists {
+		return defaultValue
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		env.invalid(key, value, "a duration such as 30s")
+		return defaultValue
+	}
+	return duration
+}
+
+// GetList reads a comma-separated list.
+func GetList(key string, defaultValue []string) []string {
+	value, exists := env.get(key, strings.Join(defaultValue, ","))
+	if !exists {
+		return defaultValue
+	}
+	return splitList(value)
+}
+
+// splitList splits a comma-separated value, trimming spaces and dropping
+// empty items.
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// loadConfigFile reads a flat JSON or TOML file, chosen by extension, whose
+// keys are the environment variable names, e.g. {"APP_PORT": 8080} or
+// app_port = 8080. Lists may be arrays.
+func loadConfigFile(path string) (map[string]string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var raw map[string]any
+	switch ext := filepath.Ext(path); ext {
+	case ".json":
+		err = json.Unmarshal(content, &raw)
+	case ".toml":
+		err = toml.Unmarshal(content, &raw)
+	default:
+		return nil, fmt.Errorf("config file %s must end in .json or .toml", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parsing config file %s: %w", path, err)
+	}
+
+	values := make(map[string]string, len(raw))
+	for key, value := range raw {
+		str, err := configFileValue(value)
+		if err != nil {
+			return nil, fmt.Errorf("config file %s: %s: %w", path, key, err)
+		}
+		values[strings.ToUpper(key)] = str
+	}
+	return values, nil
+}
+
+func configFileValue(value any) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case []any:
+		items := make([]string, len(v))
+		for i, item := range v {
+			str, err := configFileValue(item)
+			if err != nil {
+				return "", err
+			}
+			items[i] = str
+		}
+		return strings.Join(items, ","), nil
+	default:
+		return "", fmt.Errorf("unsupported value of type %T", value)
+	}
+}
+
+// printConfig writes every setting that was read, in .env format, with
+// secrets redacted.
+func (s *settings) printConfig(w io.Writer) {
+	for _, key := range slices.Sorted(maps.Keys(s.values)) {
+		value := s.values[key]
+		if s.secrets[key] && value != "" {
+			value = "[redacted]"
+		}
+		fmt.Fprintf(w, "%s=%s\n", key, strconv.Quote(value))
+	}
+}
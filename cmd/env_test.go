@@ -1,8 +1,13 @@
 package main
 
 import (
+	"maps"
 	"os"
+	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestGetString(t *testing.T) {
@@ -112,3 +117,137 @@ func TestGetInt(t *testing.T) {
 		})
 	}
 }
+
+func TestGetBool(t *testing.T) {
+	t.Setenv("TEST_BOOL", "true")
+	t.Setenv("TEST_BOOL_INVALID", "maybe")
+
+	if got := GetBool("TEST_BOOL", false); !got {
+		t.Errorf("GetBool() = %v, want true", got)
+	}
+	if got := GetBool("TEST_BOOL_UNSET", true); !got {
+		t.Errorf("GetBool() = %v, want default true", got)
+	}
+	if got := GetBool("TEST_BOOL_INVALID", true); !got {
+		t.Errorf("GetBool() = %v, want default true", got)
+	}
+}
+
+func TestGetDuration(t *testing.T) {
+	t.Setenv("TEST_DURATION", "1m30s")
+	t.Setenv("TEST_DURATION_INVALID", "30")
+
+	if got := GetDuration("TEST_DURATION", time.Second); got != 90*time.Second {
+		t.Errorf("GetDuration() = %v, want 1m30s", got)
+	}
+	if got := GetDuration("TEST_DURATION_UNSET", time.Second); got != time.Second {
+		t.Errorf("GetDuration() = %v, want default 1s", got)
+	}
+	if got := GetDuration("TEST_DURATION_INVALID", time.Second); got != time.Second {
+		t.Errorf("GetDuration() = %v, want default 1s", got)
+	}
+}
+
+func TestGetList(t *testing.T) {
+	t.Setenv("TEST_LIST", " a, b,,c ")
+
+	if got := GetList("TEST_LIST", nil); !slices.Equal(got, []string{"a", "b", "c"}) {
+		t.Errorf("GetList() = %q, want [a b c]", got)
+	}
+	if got := GetList("TEST_LIST_UNSET", []string{"x"}); !slices.Equal(got, []string{"x"}) {
+		t.Errorf("GetList() = %q, want default [x]", got)
+	}
+}
+
+func TestSettingsCollectErrors(t *testing.T) {
+	defer func(saved *settings) { env = saved }(env)
+	env = newSettings(nil)
+	t.Setenv("TEST_INT_INVALID", "x")
+	t.Setenv("TEST_BOOL_INVALID", "x")
+
+	GetInt("TEST_INT_INVALID", 1)
+	GetBool("TEST_BOOL_INVALID", false)
+
+	if len(env.errs) != 2 {
+		t.Fatalf("got %d errors, want 2: %v", len(env.errs), env.errs)
+	}
+	if got := env.errs[0].Error(); got != `TEST_INT_INVALID: "x" is not an integer` {
+		t.Errorf("error = %q", got)
+	}
+}
+
+func TestLoadConfigFile(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"config.json": `{"APP_PORT": 8080, "app_env": "development", "RATE_LIMIT_SCRIPT_BURST": 5.0, "ALLOWED_HOSTS": ["a.example", "b.example"], "METRICS_TOKEN": "secret"}`,
+		"config.toml": "APP_PORT = 8080\napp_env = \"development\"\nRATE_LIMIT_SCRIPT_BURST = 5\nALLOWED_HOSTS = [\"a.example\", \"b.example\"]\nMETRICS_TOKEN = \"secret\"\n",
+	}
+
+	for name, content := range files {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(dir, name)
+			if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			values, err := loadConfigFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := map[string]string{
+				"APP_PORT":                "8080",
+				"APP_ENV":                 "development",
+				"RATE_LIMIT_SCRIPT_BURST": "5",
+				"ALLOWED_HOSTS":           "a.example,b.example",
+				"METRICS_TOKEN":           "secret",
+			}
+			if !maps.Equal(values, want) {
+				t.Errorf("values = %v, want %v", values, want)
+			}
+		})
+	}
+}
+
+func TestLoadConfigFileErrors(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"config.yaml":  "APP_PORT: 80",
+		"invalid.json": `{"APP_PORT": }`,
+		"nested.toml":  "[server]\nport = 80\n",
+		"missing.json": "",
+	}
+
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if name != "missing.json" {
+			if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if _, err := loadConfigFile(path); err == nil {
+			t.Errorf("loadConfigFile(%s) error = nil, want error", name)
+		}
+	}
+}
+
+func TestSettingsPrecedenceAndPrintConfig(t *testing.T) {
+	defer func(saved *settings) { env = saved }(env)
+	env = newSettings(map[string]string{"TEST_FILE_ONLY": "file", "TEST_BOTH": "file", "TEST_SECRET": "hunter2"})
+	t.Setenv("TEST_BOTH", "env")
+
+	if got := GetString("TEST_FILE_ONLY", ""); got != "file" {
+		t.Errorf("file value = %q, want file", got)
+	}
+	if got := GetString("TEST_BOTH", ""); got != "env" {
+		t.Errorf("environment value = %q, want env", got)
+	}
+	GetSecret("TEST_SECRET", "")
+	GetSecret("TEST_EMPTY_SECRET", "")
+
+	var out strings.Builder
+	env.printConfig(&out)
+	want := "TEST_BOTH=\"env\"\nTEST_EMPTY_SECRET=\"\"\nTEST_FILE_ONLY=\"file\"\nTEST_SECRET=\"[redacted]\"\n"
+	if out.String() != want {
+		t.Errorf("printConfig() =\n%s\nwant\n%s", out.String(), want)
+	}
+}
@@ -1,20 +1,58 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log/slog"
 	"net/http"
 	"runtime/debug"
+	"strconv"
 )
 
+// problem is an RFC 7807 problem details object. Type is a URI reference that
+// identifies the kind of error for clients; Instance is the request ID.
+type problem struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+}
+
+// problemTypes describes each problem kind. GET /problems/{kind} serves the
+// description, so the type URIs in error responses resolve.
+var problemTypes = map[string]string{
+	"server-error":       "The server failed while handling the request. The instance names the request in the server logs.",
+	"not-found":          "No resource exists at the requested path.",
+	"method-not-allowed": "The resource exists but does not support the request method. The Allow header lists the methods it does support.",
+	"invalid-query":      "A query parameter is unknown or has an invalid value.",
+	"unauthorized":       "The request did not carry valid credentials for the resource.",
+	"unknown-host":       "This server does not serve the host the request was sent to.",
+	"rate-limited":       "The client sent too many requests. Retry after the number of seconds in the Retry-After header.",
+
+	"invalid-request":       "The relay request body is not a valid generate request, for example malformed JSON, a missing diff or a model the server does not allow.",
+	"request-too-large":     "The relay request body is larger than the server accepts.",
+	"quota-exceeded":        "The relay token has used up its daily quota, which resets at midnight UTC. The Retry-After header holds the seconds until then.",
+	"upstream-rate-limited": "The model provider behind the relay is rate limiting the server. Retry after the number of seconds in the Retry-After header.",
+	"upstream-timeout":      "The model provider behind the relay did not respond in time.",
+	"upstream-error":        "The model provider behind the relay failed or returned an unusable response.",
+}
+
+func newProblem(status int, kind, detail string) problem {
+	return problem{Type: "/problems/" + kind, Status: status, Detail: detail}
+}
+
 func (app *application) reportServerError(r *http.Request, err error) {
 	var (
 		message = err.Error()
 		method  = r.Method
 		url     = r.URL.String()
+		id      = requestID(r)
 		trace   = string(debug.Stack())
 	)
 
-	requestAttrs := slog.Group("request", "method", method, "url", url)
+	requestAttrs := slog.Group("request", "id", id, "method", method, "url", url)
 	app.logger.Error(message, requestAttrs, "trace", trace)
 }
 
@@ -22,10 +60,85 @@ func (app *application) serverError(w http.ResponseWriter, r *http.Request, err
 	app.reportServerError(r, err)
 
 	message := "The server encountered a problem and could not process your request"
-	respond(w, r, http.StatusInternalServerError, message)
+	app.writeProblem(w, r, newProblem(http.StatusInternalServerError, "server-error", message))
 }
 
 func (app *application) notFound(w http.ResponseWriter, r *http.Request) {
 	message := "The requested resource could not be found"
-	respond(w, r, http.StatusNotFound, message)
+	app.writeProblem(w, r, newProblem(http.StatusNotFound, "not-found", message))
+}
+
+func (app *application) methodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	message := fmt.Sprintf("The %s method is not supported for this resource", r.Method)
+	app.writeProblem(w, r, newProblem(http.StatusMethodNotAllowed, "method-not-allowed", message))
+}
+
+func (app *application) badRequest(w http.ResponseWriter, r *http.Request, err error) {
+	app.writeProblem(w, r, newProblem(http.StatusBadRequest, "invalid-query", err.Error()))
+}
+
+func (app *application) unauthorized(w http.ResponseWriter, r *http.Request, message string) {
+	app.writeProblem(w, r, newProblem(http.StatusUnauthorized, "unauthorized", message))
+}
+
+func (app *application) misdirectedRequest(w http.ResponseWriter, r *http.Request) {
+	message := "This server does not serve the requested host"
+	app.writeProblem(w, r, newProblem(http.StatusMisdirectedRequest, "unknown-host", message))
+}
+
+func (app *application) tooManyRequests(w http.ResponseWriter, r *http.Request, retryAfter int) {
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+
+	message := fmt.Sprintf("Too many requests, try again in %d seconds", retryAfter)
+	app.writeProblem(w, r, newProblem(http.StatusTooManyRequests, "rate-limited", message))
+}
+
+func (app *application) quotaExceeded(w http.ResponseWriter, r *http.Request, retryAfter int) {
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+
+	message := "The daily quota for this token is used up, it resets at midnight UTC"
+	app.writeProblem(w, r, newProblem(http.StatusTooManyRequests, "quota-exceeded", message))
+}
+
+func (app *application) invalidRequest(w http.ResponseWriter, r *http.Request, err error) {
+	app.writeProblem(w, r, newProblem(http.StatusBadRequest, "invalid-request", err.Error()))
+}
+
+func (app *application) requestTooLarge(w http.ResponseWriter, r *http.Request, limit int64) {
+	message := fmt.Sprintf("The request body must not exceed %d bytes", limit)
+	app.writeProblem(w, r, newProblem(http.StatusRequestEntityTooLarge, "request-too-large", message))
+}
+
+// upstreamFailed reports that the model provider behind the relay failed. The
+// error is only logged; the client gets the status, passing a provider rate
+// limit on as 429 with its Retry-After, timeouts as 504 and anything else as
+// 502.
+func (app *application) upstreamFailed(w http.ResponseWriter, r *http.Request, err error) {
+	app.reportServerError(r, err)
+
+	message := "The model provider failed"
+	var upstream *upstreamError
+	switch {
+	case errors.As(err, &upstream) && upstream.status == http.StatusTooManyRequests:
+		if upstream.retryAfter != "" {
+			w.Header().Set("Retry-After", upstream.retryAfter)
+		}
+		app.writeProblem(w, r, newProblem(http.StatusTooManyRequests, "upstream-rate-limited", message))
+	case errors.Is(err, context.DeadlineExceeded):
+		app.writeProblem(w, r, newProblem(http.StatusGatewayTimeout, "upstream-timeout", "The model provider did not respond in time"))
+	default:
+		app.writeProblem(w, r, newProblem(http.StatusBadGateway, "upstream-error", message))
+	}
+}
+
+func (app *application) handleProblemType(w http.ResponseWriter, r *http.Request) {
+	kind := r.PathValue("kind")
+	description, ok := problemTypes[kind]
+	if !ok {
+		app.notFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintf(w, "%s\n\n%s\n", kind, description)
 }
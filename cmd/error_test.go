@@ -28,7 +28,7 @@ func TestErrorPages(t *testing.T) {
 			req.Header.Set("User-Agent", "Mozilla/5.0")
 			rr := httptest.NewRecorder()
 
-			respond(rr, req, tt.statusCode, tt.message)
+			newTestApp().writeProblem(rr, req, problem{Type: "about:blank", Status: tt.statusCode, Detail: tt.message})
 
 			if rr.Code != tt.statusCode {
 				t.Fatalf("status = %d, want %d", rr.Code, tt.statusCode)
@@ -98,7 +98,7 @@ func TestErrorResponseJSON(t *testing.T) {
 			tt.setHeader(req)
 			rr := httptest.NewRecorder()
 
-			respond(rr, req, http.StatusNotFound, "The page could not be found")
+			newTestApp().writeProblem(rr, req, newProblem(http.StatusNotFound, "not-found", "The page could not be found"))
 
 			if rr.Code != http.StatusNotFound {
 				t.Fatalf("status = %d, want %d", rr.Code, http.StatusNotFound)
@@ -122,7 +122,7 @@ func TestErrorPageEscapesMessage(t *testing.T) {
 	req := httptest.NewRequest(http.MethodGet, "http://commit.jaw.dev/missing", nil)
 	rr := httptest.NewRecorder()
 
-	respond(rr, req, http.StatusBadRequest, `<script>alert("no")</script>`)
+	newTestApp().writeProblem(rr, req, newProblem(http.StatusBadRequest, "invalid-query", `<script>alert("no")</script>`))
 
 	if strings.Contains(rr.Body.String(), `<script>alert("no")</script>`) {
 		t.Error("response contains an unescaped error message")
@@ -131,3 +131,115 @@ func TestErrorPageEscapesMessage(t *testing.T) {
 		t.Error("response does not contain the escaped error message")
 	}
 }
+
+func TestProblemJSON(t *testing.T) {
+	app := newTestApp()
+	app.config.rateLimits = map[string]rateLimit{"script": {perMinute: 1, burst: 2}}
+	handler := app.routes()
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		status int
+		kind   string
+	}{
+		{"not found", http.MethodGet, "/missing", http.StatusNotFound, "/problems/not-found"},
+		{"bad query", http.MethodGet, "/?unknown=1", http.StatusBadRequest, "/problems/invalid-query"},
+		{"method not allowed", http.MethodPost, "/commit.sh", http.StatusMethodNotAllowed, "/problems/method-not-allowed"},
+		{"rate limited", http.MethodGet, "/commit.sh", http.StatusTooManyRequests, "/problems/rate-limited"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "http://commit.jaw.dev"+tt.path, nil)
+			req.Header.Set("Accept", "application/problem+json")
+			req.RemoteAddr = "192.0.2.1:1234"
+			rr := httptest.NewRecorder()
+
+			handler.ServeHTTP(rr, req)
+
+			if rr.Code != tt.status {
+				t.Fatalf("status = %d, want %d", rr.Code, tt.status)
+			}
+			if got := rr.Header().Get("Content-Type"); got != "application/problem+json" {
+				t.Errorf("Content-Type = %q, want application/problem+json", got)
+			}
+
+			var body problem
+			if err := json.NewDecoder(rr.Body).Decode(&body); err != nil {
+				t.Fatal(err)
+			}
+			if body.Type != tt.kind {
+				t.Errorf("type = %q, want %q", body.Type, tt.kind)
+			}
+			if body.Title != http.StatusText(tt.status) || body.Status != tt.status || body.Detail == "" {
+				t.Errorf("problem = %+v", body)
+			}
+			if body.Instance == "" || body.Instance != rr.Header().Get("X-Request-Id") {
+				t.Errorf("instance = %q, want the request ID %q", body.Instance, rr.Header().Get("X-Request-Id"))
+			}
+
+			typeReq := httptest.NewRequest(http.MethodGet, "http://commit.jaw.dev"+body.Type, nil)
+			typeRR := httptest.NewRecorder()
+			handler.ServeHTTP(typeRR, typeReq)
+			if typeRR.Code != http.StatusOK {
+				t.Errorf("GET %s status = %d, want %d", body.Type, typeRR.Code, http.StatusOK)
+			}
+		})
+	}
+}
+
+func TestMethodNotAllowedUsesErrorResponse(t *testing.T) {
+	app := newTestApp()
+	req := httptest.NewRequest(http.MethodDelete, "http://commit.jaw.dev/pubkey", nil)
+	req.Header.Set("Accept", "application/json")
+	rr := httptest.NewRecorder()
+
+	app.routes().ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("status = %d, want %d", rr.Code, http.StatusMethodNotAllowed)
+	}
+	if got := rr.Header().Get("Allow"); !strings.Contains(got, "GET") {
+		t.Errorf("Allow = %q, want it to list GET", got)
+	}
+	if got := rr.Header().Get("Content-Type"); got != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", got)
+	}
+	if !strings.Contains(rr.Body.String(), `"message":"The DELETE method is not supported for this resource"`) {
+		t.Errorf("body = %q", rr.Body.String())
+	}
+}
+
+func TestProblemTypePages(t *testing.T) {
+	app := newTestApp()
+	handler := app.routes()
+
+	for kind, description := range problemTypes {
+		t.Run(kind, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "http://commit.jaw.dev/problems/"+kind, nil)
+			rr := httptest.NewRecorder()
+
+			handler.ServeHTTP(rr, req)
+
+			if rr.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d", rr.Code, http.StatusOK)
+			}
+			if got := rr.Header().Get("Content-Type"); got != "text/plain; charset=utf-8" {
+				t.Errorf("Content-Type = %q, want text/plain; charset=utf-8", got)
+			}
+			if !strings.Contains(rr.Body.String(), description) {
+				t.Errorf("body = %q, want the description of %s", rr.Body.String(), kind)
+			}
+		})
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "http://commit.jaw.dev/problems/unknown", nil)
+	req.Header.Set("Accept", "application/json")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("unknown kind status = %d, want %d", rr.Code, http.StatusNotFound)
+	}
+}
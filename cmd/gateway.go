@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// generation collects what the audit log records about one request to the
+// generate endpoint. gatewayMiddleware creates it and handleGenerate fills it
+// in.
+type generation struct {
+	tokenID       string
+	user          string
+	model         string
+	diff          string
+	diffSHA256    string
+	diffBytes     int
+	diffStatBytes int
+	messageBytes  int
+	usage         chatUsage
+}
+
+type generationKey struct{}
+
+// generationFrom returns the generation for r, or a throwaway one when the
+// request did not pass through gatewayMiddleware.
+func generationFrom(r *http.Request) *generation {
+	if gen, ok := r.Context().Value(generationKey{}).(*generation); ok {
+		return gen
+	}
+	return &generation{}
+}
+
+func (gen *generation) setDiff(diff, diffStat string) {
+	sum := sha256.Sum256([]byte(diff))
+	gen.diff = diff
+	gen.diffSHA256 = hex.EncodeToString(sum[:])
+	gen.diffBytes = len(diff)
+	gen.diffStatBytes = len(diffStat)
+}
+
+// auditEntry is one line of the audit log. Diff is only set with
+// AUDIT_LOG_DIFFS.
+type auditEntry struct {
+	Time             string `json:"time"`
+	RequestID        string `json:"request_id"`
+	TokenID          string `json:"token_id,omitempty"`
+	User             string `json:"user,omitempty"`
+	Status           int    `json:"status"`
+	Model            string `json:"model,omitempty"`
+	DiffSHA256       string `json:"diff_sha256,omitempty"`
+	DiffBytes        int    `json:"diff_bytes"`
+	DiffStatBytes    int    `json:"diff_stat_bytes"`
+	MessageBytes     int    `json:"message_bytes"`
+	PromptTokens     int64  `json:"prompt_tokens"`
+	CompletionTokens int64  `json:"completion_tokens"`
+	TotalTokens      int64  `json:"total_tokens"`
+	DurationMS       int64  `json:"duration_ms"`
+	Diff             string `json:"diff,omitempty"`
+}
+
+// auditLog appends one JSON object per line to a file that is only ever
+// opened for appending.
+type auditLog struct {
+	mu           sync.Mutex
+	file         *os.File
+	includeDiffs bool
+}
+
+func openAuditLog(path string, includeDiffs bool) (*auditLog, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	return &auditLog{file: file, includeDiffs: includeDiffs}, nil
+}
+
+func (l *auditLog) write(entry auditEntry) error {
+	if !l.includeDiffs {
+		entry.Diff = ""
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, err = l.file.Write(append(line, '\n'))
+	return err
+}
+
+func (l *auditLog) Close() error {
+	return l.file.Close()
+}
+
+// gatewayMiddleware guards the generate endpoint. With AUTH_DB set it
+// requires a bearer token and enforces the token's daily quotas; with
+// AUDIT_LOG set it records every request it lets through or rejects for
+// quota.
+func (app *application) gatewayMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		gen := &generation{}
+		day := usageDay(start)
+
+		if app.tokens != nil {
+			token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			record, err := app.tokens.authenticate(token, app.config.quota)
+			if !ok || errors.Is(err, errUnknownToken) {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="commit"`)
+				app.unauthorized(w, r, "A valid API token is required")
+				return
+			}
+			if err != nil {
+				app.serverError(w, r, err)
+				return
+			}
+			gen.tokenID, gen.user = record.id, record.user
+
+			allowed, err := app.tokens.reserve(record, day)
+			if err != nil {
+				app.serverError(w, r, err)
+				return
+			}
+			if !allowed {
+				app.quotaExceeded(w, r, int(math.Ceil(untilNextDay(start).Seconds())))
+				app.writeAudit(r, gen, http.StatusTooManyRequests, start)
+				return
+			}
+		}
+
+		sw := &statusWriter{ResponseWriter: w}
+		next.ServeHTTP(sw, r.WithContext(context.WithValue(r.Context(), generationKey{}, gen)))
+
+		if app.tokens != nil && gen.usage.TotalTokens > 0 {
+			if err := app.tokens.addTokens(gen.tokenID, day, gen.usage.TotalTokens); err != nil {
+				app.reportServerError(r, err)
+			}
+		}
+		app.writeAudit(r, gen, sw.statusCode(), start)
+	})
+}
+
+func (app *application) writeAudit(r *http.Request, gen *generation, status int, start time.Time) {
+	if app.audit == nil {
+		return
+	}
+
+	err := app.audit.write(auditEntry{
+		Time:             start.UTC().Format(time.RFC3339Nano),
+		RequestID:        requestID(r),
+		TokenID:          gen.tokenID,
+		User:             gen.user,
+		Status:           status,
+		Model:            gen.model,
+		DiffSHA256:       gen.diffSHA256,
+		DiffBytes:        gen.diffBytes,
+		DiffStatBytes:    gen.diffStatBytes,
+		MessageBytes:     gen.messageBytes,
+		PromptTokens:     gen.usage.PromptTokens,
+		CompletionTokens: gen.usage.CompletionTokens,
+		TotalTokens:      gen.usage.TotalTokens,
+		DurationMS:       time.Since(start).Milliseconds(),
+		Diff:             gen.diff,
+	})
+	if err != nil {
+		app.reportServerError(r, err)
+	}
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+const usageReply = `{"model":"openrouter/free","choices":[{"message":{"content":"feat: add gateway"}}],"usage":{"prompt_tokens":40,"completion_tokens":10,"total_tokens":50}}`
+
+func newGatewayApp(t *testing.T, upstream *fakeUpstream) (*application, string) {
+	t.Helper()
+	app := newRelayApp(upstream)
+	app.tokens, _ = newTestTokenStore(t)
+	app.config.quota = quota{requests: 10, tokens: 1000}
+
+	auditPath := filepath.Join(t.TempDir(), "audit.jsonl")
+	audit, err := openAuditLog(auditPath, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { audit.Close() })
+	app.audit = audit
+	return app, auditPath
+}
+
+func postGenerateWithToken(app *application, token, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "http://commit.example.com/api/v1/generate", strings.NewReader(body))
+	req.Header.Set("Accept", "application/problem+json, application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rr := httptest.NewRecorder()
+	app.routes().ServeHTTP(rr, req)
+	return rr
+}
+
+func readAudit(t *testing.T, path string) []auditEntry {
+	t.Helper()
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	var entries []auditEntry
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry auditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatalf("audit line %q: %v", scanner.Text(), err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func TestGatewayRequiresToken(t *testing.T) {
+	upstream := newFakeUpstream(t, replyWith(http.StatusOK, usageReply))
+	app, auditPath := newGatewayApp(t, upstream)
+	token, record, err := app.tokens.create("alice", nil, nil, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := app.tokens.revoke(record.id, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, token := range []string{"", "cmt_unknown", token} {
+		rr := postGenerateWithToken(app, token, `{"diff":"x"}`)
+
+		if rr.Code != http.StatusUnauthorized {
+			t.Errorf("token %q: status = %d, want %d", token, rr.Code, http.StatusUnauthorized)
+		}
+		if got := rr.Header().Get("WWW-Authenticate"); got != `Bearer realm="commit"` {
+			t.Errorf("token %q: WWW-Authenticate = %q", token, got)
+		}
+	}
+	if upstream.calls != 0 {
+		t.Errorf("upstream was called %d times", upstream.calls)
+	}
+	if entries := readAudit(t, auditPath); len(entries) != 0 {
+		t.Errorf("audit log has %d entries for rejected tokens", len(entries))
+	}
+}
+
+func TestGatewayAuditsAndCountsUsage(t *testing.T) {
+	upstream := newFakeUpstream(t, replyWith(http.StatusOK, usageReply))
+	app, auditPath := newGatewayApp(t, upstream)
+	token, record, err := app.tokens.create("alice", nil, nil, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	diff := "+secret business logic\n"
+	rr := postGenerateWithToken(app, token, `{"diff":"+secret business logic\n","diff_stat":"a.go | 1 +"}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", rr.Code, http.StatusOK, rr.Body)
+	}
+
+	_, usages, err := app.tokens.list(app.config.quota, usageDay(time.Now()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usages[0] != (tokenUsage{requests: 1, tokens: 50}) {
+		t.Errorf("usage = %+v, want 1 request and 50 tokens", usages[0])
+	}
+
+	content, err := os.ReadFile(auditPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(content, []byte("secret business logic")) {
+		t.Error("audit log contains the diff")
+	}
+	entries := readAudit(t, auditPath)
+	if len(entries) != 1 {
+		t.Fatalf("audit log has %d entries, want 1", len(entries))
+	}
+	entry := entries[0]
+	want := auditEntry{
+		TokenID:          record.id,
+		User:             "alice",
+		Status:           http.StatusOK,
+		Model:            "openrouter/free",
+		DiffSHA256:       hashToken(diff),
+		DiffBytes:        len(diff),
+		DiffStatBytes:    len("a.go | 1 +"),
+		MessageBytes:     len("feat: add gateway"),
+		PromptTokens:     40,
+		CompletionTokens: 10,
+		TotalTokens:      50,
+	}
+	entry.Time, entry.RequestID, entry.DurationMS = "", "", 0
+	if entry != want {
+		t.Errorf("audit entry = %+v\nwant %+v", entry, want)
+	}
+}
+
+func TestGatewayAuditLogDiffs(t *testing.T) {
+	upstream := newFakeUpstream(t, replyWith(http.StatusOK, usageReply))
+	app, auditPath := newGatewayApp(t, upstream)
+	app.audit.includeDiffs = true
+	token, _, err := app.tokens.create("alice", nil, nil, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	postGenerateWithToken(app, token, `{"diff":"+logged\n"}`)
+
+	if entries := readAudit(t, auditPath); len(entries) != 1 || entries[0].Diff != "+logged\n" {
+		t.Errorf("audit entries = %+v, want the diff", entries)
+	}
+}
+
+func TestGatewayQuotas(t *testing.T) {
+	tests := []struct {
+		name          string
+		dailyRequests int64
+		dailyTokens   int64
+		allowed       int
+	}{
+		{"requests", 2, 0, 2},
+		{"tokens", 0, 60, 2},
+		{"unlimited", 0, 0, 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream := newFakeUpstream(t, replyWith(http.StatusOK, usageReply))
+			app, auditPath := newGatewayApp(t, upstream)
+			token, _, err := app.tokens.create("alice", &tt.dailyRequests, &tt.dailyTokens, time.Now())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var statuses []int
+			for range 4 {
+				rr := postGenerateWithToken(app, token, `{"diff":"x"}`)
+				statuses = append(statuses, rr.Code)
+				if rr.Code != http.StatusTooManyRequests {
+					continue
+				}
+				retryAfter, err := strconv.Atoi(rr.Header().Get("Retry-After"))
+				if err != nil || retryAfter < 1 || retryAfter > 24*60*60 {
+					t.Errorf("Retry-After = %q, want seconds until midnight UTC", rr.Header().Get("Retry-After"))
+				}
+				if !strings.Contains(rr.Body.String(), "/problems/quota-exceeded") {
+					t.Errorf("body = %s, want a quota-exceeded problem", rr.Body)
+				}
+			}
+
+			for i, status := range statuses {
+				want := http.StatusOK
+				if i >= tt.allowed {
+					want = http.StatusTooManyRequests
+				}
+				if status != want {
+					t.Errorf("request %d: status = %d, want %d", i+1, status, want)
+				}
+			}
+			if upstream.calls != tt.allowed {
+				t.Errorf("upstream calls = %d, want %d", upstream.calls, tt.allowed)
+			}
+			if entries := readAudit(t, auditPath); len(entries) != 4 {
+				t.Errorf("audit log has %d entries, want 4", len(entries))
+			}
+		})
+	}
+}
+
+func TestCommitScriptSendsRelayToken(t *testing.T) {
+	if _, err := exec.LookPath("curl"); err != nil {
+		t.Skip("curl is not installed")
+	}
+
+	upstream := newFakeUpstream(t, replyWith(http.StatusOK, usageReply))
+	app, _ := newGatewayApp(t, upstream)
+	token, _, err := app.tokens.create("alice", nil, nil, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(app.routes())
+	defer server.Close()
+
+	var script bytes.Buffer
+	if err := renderScript(&script, "commit.sh", scriptData{Domain: server.URL, Brand: app.brand(), Relay: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	root := t.TempDir()
+	runGit(t, root, "init", "-q")
+	if err := os.WriteFile(filepath.Join(root, "feature.txt"), []byte("new feature\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	runGit(t, root, "add", "feature.txt")
+
+	run := func(token string) (string, error) {
+		cmd := exec.Command("bash", "-s", "--", "--dry-run")
+		cmd.Dir = root
+		cmd.Stdin = bytes.NewReader(script.Bytes())
+		cmd.Env = append(os.Environ(),
+			"XDG_CONFIG_HOME="+filepath.Join(root, "config"),
+			"XDG_CACHE_HOME="+filepath.Join(root, "cache"),
+			"TMPDIR="+root,
+			"COMMIT_MODEL=",
+			"COMMIT_TOKEN="+token,
+		)
+		output, err := cmd.CombinedOutput()
+		return string(output), err
+	}
+
+	if output, err := run(""); err == nil || !strings.Contains(output, "A valid API token is required") {
+		t.Errorf("without a token: err = %v, output:\n%s", err, output)
+	}
+	output, err := run(token)
+	if err != nil || !strings.Contains(output, "feat: add gateway") {
+		t.Errorf("with a token: err = %v, output:\n%s", err, output)
+	}
+	if upstream.calls != 1 {
+		t.Errorf("upstream calls = %d, want 1", upstream.calls)
+	}
+	leftovers, _ := filepath.Glob(filepath.Join(root, "commit-auth.*"))
+	if len(leftovers) != 0 {
+		t.Errorf("auth header files were left behind: %v", leftovers)
+	}
+}
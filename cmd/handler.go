@@ -2,85 +2,93 @@ package main
 
 import (
 	"bytes"
+	"cmp"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
-	"html/template"
-	"io"
 	"net/http"
+	"path"
 	"strings"
-
-	"github.com/wajeht/commit/assets"
-)
-
-var (
-	homeTemplate    = pageTemplate("templates/index.html")
-	installTemplate = pageTemplate("templates/install.html")
 )
 
 type pageData struct {
-	Title   string
-	Domain  string
-	Command string
-}
-
-func pageTemplate(page string) *template.Template {
-	return template.Must(template.ParseFS(assets.Embeddedfiles, "templates/base.html", page))
+	Title     string
+	Nonce     string
+	Brand     branding
+	Relay     bool
+	Domain    string
+	ScriptURL string
+	Command   string
+	// MaxDiffSize is the diff size limit of the script the page links to.
+	MaxDiffSize string
 }
 
 func (app *application) handleHealthz(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "text/plain")
+	if app.draining.Load() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte("draining"))
+		return
+	}
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte("ok"))
 }
 
 func (app *application) handleFavicon(w http.ResponseWriter, r *http.Request) {
-	file, err := assets.Embeddedfiles.Open("static/favicon.ico")
-	if err != nil {
-		app.serverError(w, r, err)
-		return
-	}
-	defer file.Close()
-
-	w.Header().Set("Content-Type", "image/x-icon")
-	if _, err := io.Copy(w, file); err != nil {
-		app.reportServerError(r, err)
-	}
+	app.serveEmbedded(w, r, "static/favicon.ico", "image/x-icon")
 }
 
 func (app *application) handleRobotsTxt(w http.ResponseWriter, r *http.Request) {
-	file, err := assets.Embeddedfiles.Open("static/robots.txt")
+	app.serveEmbedded(w, r, "static/robots.txt", "text/plain")
+}
+
+// serveEmbedded writes a static asset with a strong ETag, answering
+// matching If-None-Match requests with 304 Not Modified.
+func (app *application) serveEmbedded(w http.ResponseWriter, r *http.Request, name, contentType string) {
+	content, err := siteAssets.readFile(name)
 	if err != nil {
 		app.serverError(w, r, err)
 		return
 	}
-	defer file.Close()
 
-	w.Header().Set("Content-Type", "text/plain")
-	if _, err := io.Copy(w, file); err != nil {
+	if notModified(w, r, strongETag(content)) {
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(content); err != nil {
 		app.reportServerError(r, err)
 	}
 }
 
 func (app *application) handleInstallSh(w http.ResponseWriter, r *http.Request) {
 	domain := app.domain(r)
+	varyNegotiated(w)
 
-	userAgent := r.Header.Get("User-Agent")
-	isCurl := strings.Contains(userAgent, "curl")
-
-	if !isCurl {
+	if representation := negotiate(r, mediaHTML, mediaJSON, mediaScript); representation != mediaScript {
 		command := fmt.Sprintf("curl -fsSL %s/install.sh | bash", domain)
 		message := "Run this command from your terminal:"
-		accept := r.Header.Get("Accept")
 
-		if strings.Contains(accept, "application/json") {
+		if representation == mediaJSON {
 			w.Header().Set("Content-Type", "application/json")
 			w.WriteHeader(http.StatusOK)
 			fmt.Fprintf(w, `{"message":"%s %s"}`, message, command)
 			return
 		}
 
+		tmpl, err := siteAssets.page("install.html")
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+
 		var page bytes.Buffer
-		if err := installTemplate.ExecuteTemplate(&page, "base.html", pageData{
-			Title:   "Install Commit",
+		if err := tmpl.ExecuteTemplate(&page, "base.html", pageData{
+			Title:   "Install " + app.brand().Name,
+			Nonce:   cspNonce(r),
+			Brand:   app.brand(),
 			Domain:  domain,
 			Command: command,
 		}); err != nil {
@@ -96,20 +104,11 @@ func (app *application) handleInstallSh(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	file, err := assets.Embeddedfiles.Open("sh/install.sh")
-	if err != nil {
-		app.serverError(w, r, err)
+	script, ok := app.requestedScript(w, r, "install.sh")
+	if !ok {
 		return
 	}
-	defer file.Close()
-
-	w.Header().Set("Content-Type", "text/plain")
-	w.Header().Set("Content-Disposition", "attachment; filename=install.sh")
-	w.Header().Set("Cache-Control", "public, max-age=2592000")
-	w.WriteHeader(http.StatusOK)
-	if _, err := io.Copy(w, file); err != nil {
-		app.reportServerError(r, err)
-	}
+	app.serveScript(w, r, "install.sh", script, latestCacheControl)
 }
 
 func (app *application) handleHome(w http.ResponseWriter, r *http.Request) {
@@ -118,27 +117,43 @@ func (app *application) handleHome(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	domain := app.domain(r)
+	query := r.URL.Query()
+	defaults, err := parseScriptDefaults(query)
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
 
-	userAgent := r.Header.Get("User-Agent")
-	isCurl := strings.Contains(userAgent, "curl")
+	domain := app.domain(r)
+	scriptURL := shellScriptURL(domain, query)
+	varyNegotiated(w)
 
-	if !isCurl {
-		command := fmt.Sprintf("curl -fsSL %s | bash", domain)
+	if representation := negotiate(r, mediaHTML, mediaJSON, mediaScript); representation != mediaScript {
+		command := fmt.Sprintf("curl -fsSL %s | bash", scriptURL)
 		message := "Run this command from your terminal:"
-		accept := r.Header.Get("Accept")
 
-		if strings.Contains(accept, "application/json") {
+		if representation == mediaJSON {
 			w.Header().Set("Content-Type", "application/json")
 			w.WriteHeader(http.StatusOK)
 			fmt.Fprintf(w, `{"message":"%s %s"}`, message, command)
 			return
 		}
 
+		tmpl, err := siteAssets.page("index.html")
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+
 		var page bytes.Buffer
-		if err := homeTemplate.ExecuteTemplate(&page, "base.html", pageData{
-			Title:  "Commit",
-			Domain: domain,
+		if err := tmpl.ExecuteTemplate(&page, "base.html", pageData{
+			Title:       app.brand().Name,
+			Nonce:       cspNonce(r),
+			Brand:       app.brand(),
+			Relay:       app.config.relayAPIKey != "",
+			Domain:      domain,
+			ScriptURL:   scriptURL,
+			MaxDiffSize: formatSize(cmp.Or(defaults.MaxDiffBytes, defaultMaxDiffBytes)),
 		}); err != nil {
 			app.serverError(w, r, err)
 			return
@@ -152,26 +167,159 @@ func (app *application) handleHome(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	file, err := assets.Embeddedfiles.Open("sh/commit.sh")
-	if err != nil {
-		app.serverError(w, r, err)
+	script, ok := app.requestedScript(w, r, "commit.sh")
+	if !ok {
 		return
 	}
-	defer file.Close()
+	app.serveScript(w, r, "commit.sh", script, latestCacheControl)
+}
+
+// handleCommitSh serves the latest commit.sh regardless of the client, so it
+// has a stable URL next to its checksum and signature.
+func (app *application) handleCommitSh(w http.ResponseWriter, r *http.Request) {
+	script, ok := app.requestedScript(w, r, "commit.sh")
+	if !ok {
+		return
+	}
+	app.serveScript(w, r, "commit.sh", script, latestCacheControl)
+}
 
-	content, err := io.ReadAll(file)
+// handleVersionedScript serves commit.sh from an immutable URL. The version is
+// either the server version or the SHA-256 that /commit.sh.sha256 publishes for
+// the same host and presets, so a hash URL only ever resolves to those bytes.
+func (app *application) handleVersionedScript(w http.ResponseWriter, r *http.Request) {
+	script, ok := app.requestedScript(w, r, "commit.sh")
+	if !ok {
+		return
+	}
+
+	sum := sha256.Sum256(script)
+	requested := r.PathValue("version")
+	if requested != hex.EncodeToString(sum[:]) && (version == "dev" || requested != version) {
+		app.notFound(w, r)
+		return
+	}
+
+	app.serveScript(w, r, "commit.sh", script, immutableCacheControl)
+}
+
+// handleScriptChecksum serves "<name>.sha256" in the format read by
+// `sha256sum -c`.
+func (app *application) handleScriptChecksum(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimSuffix(path.Base(r.URL.Path), ".sha256")
+	script, ok := app.requestedScript(w, r, name)
+	if !ok {
+		return
+	}
+
+	sum := sha256.Sum256(script)
+	checksum := []byte(hex.EncodeToString(sum[:]) + "  " + name + "\n")
+
+	w.Header().Set("Cache-Control", latestCacheControl)
+	if notModified(w, r, strongETag(checksum)) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain")
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(checksum); err != nil {
+		app.reportServerError(r, err)
+	}
+}
+
+// handleScriptSignature serves the detached ed25519 signature of the script
+// exactly as it is served to the same request.
+func (app *application) handleScriptSignature(w http.ResponseWriter, r *http.Request) {
+	if app.config.signingKey == nil {
+		app.notFound(w, r)
+		return
+	}
+
+	name := strings.TrimSuffix(path.Base(r.URL.Path), ".sig")
+	script, ok := app.requestedScript(w, r, name)
+	if !ok {
+		return
+	}
+
+	signature := ed25519.Sign(app.config.signingKey, script)
+
+	w.Header().Set("Cache-Control", latestCacheControl)
+	if notModified(w, r, strongETag(signature)) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", "attachment; filename="+name+".sig")
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(signature); err != nil {
+		app.reportServerError(r, err)
+	}
+}
+
+func (app *application) handlePublicKey(w http.ResponseWriter, r *http.Request) {
+	if app.config.signingKey == nil {
+		app.notFound(w, r)
+		return
+	}
+
+	publicKey, err := publicKeyPEM(app.config.signingKey)
 	if err != nil {
 		app.serverError(w, r, err)
 		return
 	}
 
-	modifiedContent := strings.ReplaceAll(string(content), "http://localhost", domain)
+	w.Header().Set("Cache-Control", latestCacheControl)
+	if notModified(w, r, strongETag(publicKey)) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-pem-file")
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(publicKey); err != nil {
+		app.reportServerError(r, err)
+	}
+}
+
+// requestedScript renders the named script for the request's domain, applying
+// query presets to commit.sh. It writes the error response itself and reports
+// false when the script cannot be served.
+func (app *application) requestedScript(w http.ResponseWriter, r *http.Request, name string) ([]byte, bool) {
+	var defaults scriptDefaults
+	if name == "commit.sh" {
+		var err error
+		defaults, err = parseScriptDefaults(r.URL.Query())
+		if err != nil {
+			app.badRequest(w, r, err)
+			return nil, false
+		}
+	}
+
+	var script bytes.Buffer
+	if err := renderScript(&script, name, scriptData{
+		Domain:   app.domain(r),
+		Version:  version,
+		Revision: buildRevision(),
+		Brand:    app.brand(),
+		Relay:    app.config.relayAPIKey != "",
+		Defaults: defaults,
+	}); err != nil {
+		app.serverError(w, r, err)
+		return nil, false
+	}
+
+	return script.Bytes(), true
+}
+
+func (app *application) serveScript(w http.ResponseWriter, r *http.Request, name string, script []byte, cacheControl string) {
+	w.Header().Set("Cache-Control", cacheControl)
+	if notModified(w, r, strongETag(script)) {
+		return
+	}
 
 	w.Header().Set("Content-Type", "text/plain")
-	w.Header().Set("Content-Disposition", "attachment; filename=commit.sh")
-	w.Header().Set("Cache-Control", "public, max-age=2592000")
+	w.Header().Set("Content-Disposition", "attachment; filename="+name)
 	w.WriteHeader(http.StatusOK)
-	if _, err := w.Write([]byte(modifiedContent)); err != nil {
+	if _, err := w.Write(script); err != nil {
 		app.reportServerError(r, err)
 	}
 }
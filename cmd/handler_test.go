@@ -1,6 +1,12 @@
 package main
 
 import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
 	"io"
 	"log/slog"
 	"net/http"
@@ -8,6 +14,8 @@ import (
 	"os"
 	"strings"
 	"testing"
+
+	"github.com/wajeht/commit/assets"
 )
 
 func newTestApp() *application {
@@ -17,6 +25,10 @@ func newTestApp() *application {
 	}
 }
 
+func newTestSigningKey() ed25519.PrivateKey {
+	return ed25519.NewKeyFromSeed(bytes.Repeat([]byte{1}, ed25519.SeedSize))
+}
+
 func TestHandleHomeHTML(t *testing.T) {
 	app := newTestApp()
 	req := httptest.NewRequest(http.MethodGet, "http://commit.jaw.dev/", nil)
@@ -123,3 +135,356 @@ func TestHandleHomeCurl(t *testing.T) {
 		t.Error("curl response should remain a shell script")
 	}
 }
+
+func TestHandleHomeHTMLMaxDiffSize(t *testing.T) {
+	for _, tt := range []struct{ query, want string }{
+		{"", "Diffs larger than 1 MiB"},
+		{"?max_diff_bytes=2048", "Diffs larger than 2 KiB"},
+		{"?max_diff_bytes=1500", "Diffs larger than 1500 bytes"},
+	} {
+		app := newTestApp()
+		req := httptest.NewRequest(http.MethodGet, "http://commit.jaw.dev/"+tt.query, nil)
+		req.Header.Set("User-Agent", "Mozilla/5.0")
+		rr := httptest.NewRecorder()
+
+		app.handleHome(rr, req)
+
+		if !strings.Contains(rr.Body.String(), tt.want) {
+			t.Errorf("%q: page does not contain %q", tt.query, tt.want)
+		}
+	}
+}
+
+func TestHandleHomePresets(t *testing.T) {
+	app := newTestApp()
+	req := httptest.NewRequest(http.MethodGet, "http://commit.jaw.dev/?model=openrouter/auto&yes=1&lang=de&max_diff_bytes=2048", nil)
+	req.Header.Set("User-Agent", "curl/8.0.0")
+	rr := httptest.NewRecorder()
+
+	app.handleHome(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rr.Code, http.StatusOK)
+	}
+	body := rr.Body.String()
+	for _, want := range []string{
+		"PRESET_MODEL='openrouter/auto'",
+		"AUTO_ACCEPT=true",
+		"MESSAGE_LANGUAGE='de'",
+		"MAX_DIFF_BYTES=2048",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("script does not contain %q", want)
+		}
+	}
+}
+
+func TestHandleHomePresetCommand(t *testing.T) {
+	app := newTestApp()
+	req := httptest.NewRequest(http.MethodGet, "http://commit.jaw.dev/?yes=1&model=openrouter/auto", nil)
+	req.Header.Set("Accept", "application/json")
+	rr := httptest.NewRecorder()
+
+	app.handleHome(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rr.Code, http.StatusOK)
+	}
+	want := `curl -fsSL 'http://commit.jaw.dev/?model=openrouter%2Fauto&yes=1' | bash`
+	if !strings.Contains(rr.Body.String(), want) {
+		t.Errorf("response does not contain the preset command:\n%s", rr.Body.String())
+	}
+}
+
+func TestHandleHomeRejectsInvalidPresets(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		want  string
+	}{
+		{"unknown parameter", "?utm_source=x", "unknown query parameter"},
+		{"repeated parameter", "?yes=1&yes=0", "must be set once"},
+		{"model with whitespace", "?model=bad%20model", "model must be"},
+		{"model with quote", "?model=a'b", "model must be"},
+		{"invalid boolean", "?yes=maybe", "yes must be a boolean"},
+		{"invalid language", "?lang=$(id)", "lang must be"},
+		{"diff size too small", "?max_diff_bytes=1", "max_diff_bytes must be"},
+		{"diff size too large", "?max_diff_bytes=99999999", "max_diff_bytes must be"},
+		{"diff size not a number", "?max_diff_bytes=1MiB", "max_diff_bytes must be"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApp()
+			req := httptest.NewRequest(http.MethodGet, "http://commit.jaw.dev/"+tt.query, nil)
+			req.Header.Set("User-Agent", "curl/8.0.0")
+			rr := httptest.NewRecorder()
+
+			app.handleHome(rr, req)
+
+			if rr.Code != http.StatusBadRequest {
+				t.Fatalf("status = %d, want %d", rr.Code, http.StatusBadRequest)
+			}
+			if !strings.Contains(rr.Body.String(), tt.want) {
+				t.Errorf("response does not contain %q:\n%s", tt.want, rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestConditionalGet(t *testing.T) {
+	pinned := "/v/" + publishedScriptSHA256(t, newTestApp().routes(), "") + "/commit.sh"
+
+	tests := []struct {
+		name         string
+		path         string
+		cacheControl string
+	}{
+		{"latest commit script", "/", latestCacheControl},
+		{"versioned commit script", pinned, immutableCacheControl},
+		{"install script", "/install.sh", latestCacheControl},
+		{"robots", "/robots.txt", ""},
+		{"favicon", "/favicon.ico", ""},
+		{"static file", "/static/robots.txt", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApp()
+			handler := app.routes()
+
+			req := httptest.NewRequest(http.MethodGet, "http://commit.jaw.dev"+tt.path, nil)
+			req.Header.Set("User-Agent", "curl/8.0.0")
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			if rr.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d", rr.Code, http.StatusOK)
+			}
+			etag := rr.Header().Get("ETag")
+			if !strings.HasPrefix(etag, `"`) || len(etag) != 66 {
+				t.Fatalf("ETag = %q, want a strong SHA-256 ETag", etag)
+			}
+			if got := rr.Header().Get("Cache-Control"); got != tt.cacheControl {
+				t.Errorf("Cache-Control = %q, want %q", got, tt.cacheControl)
+			}
+
+			for _, ifNoneMatch := range []string{etag, `"other", W/` + etag, "*"} {
+				req := httptest.NewRequest(http.MethodGet, "http://commit.jaw.dev"+tt.path, nil)
+				req.Header.Set("User-Agent", "curl/8.0.0")
+				req.Header.Set("If-None-Match", ifNoneMatch)
+				rr := httptest.NewRecorder()
+				handler.ServeHTTP(rr, req)
+
+				if rr.Code != http.StatusNotModified {
+					t.Errorf("If-None-Match %s: status = %d, want %d", ifNoneMatch, rr.Code, http.StatusNotModified)
+				}
+				if rr.Body.Len() != 0 {
+					t.Errorf("If-None-Match %s: 304 response has a body", ifNoneMatch)
+				}
+			}
+
+			req = httptest.NewRequest(http.MethodGet, "http://commit.jaw.dev"+tt.path, nil)
+			req.Header.Set("User-Agent", "curl/8.0.0")
+			req.Header.Set("If-None-Match", `"stale"`)
+			rr = httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+			if rr.Code != http.StatusOK {
+				t.Errorf("stale ETag: status = %d, want %d", rr.Code, http.StatusOK)
+			}
+		})
+	}
+}
+
+func TestHandleVersionedScript(t *testing.T) {
+	defer func(previous string) { version = previous }(version)
+	version = "1.2.3"
+
+	sum := publishedScriptSHA256(t, newTestApp().routes(), "")
+	presetSum := publishedScriptSHA256(t, newTestApp().routes(), "?yes=1")
+	embedded, err := assets.Embeddedfiles.ReadFile("sh/commit.sh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	templateSum := sha256.Sum256(embedded)
+
+	tests := []struct {
+		name   string
+		path   string
+		status int
+	}{
+		{"script hash", "/v/" + sum + "/commit.sh", http.StatusOK},
+		{"script hash with presets", "/v/" + presetSum + "/commit.sh?yes=1", http.StatusOK},
+		{"script hash for other presets", "/v/" + sum + "/commit.sh?yes=1", http.StatusNotFound},
+		{"template hash", "/v/" + hex.EncodeToString(templateSum[:]) + "/commit.sh", http.StatusNotFound},
+		{"server version", "/v/1.2.3/commit.sh", http.StatusOK},
+		{"presets", "/v/1.2.3/commit.sh?yes=1", http.StatusOK},
+		{"invalid presets", "/v/1.2.3/commit.sh?unknown=1", http.StatusBadRequest},
+		{"other version", "/v/1.2.2/commit.sh", http.StatusNotFound},
+		{"other hash", "/v/" + strings.Repeat("0", 64) + "/commit.sh", http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApp()
+			req := httptest.NewRequest(http.MethodGet, "http://commit.jaw.dev"+tt.path, nil)
+			req.Header.Set("User-Agent", "Mozilla/5.0")
+			rr := httptest.NewRecorder()
+
+			app.routes().ServeHTTP(rr, req)
+
+			if rr.Code != tt.status {
+				t.Fatalf("status = %d, want %d", rr.Code, tt.status)
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+			if got := rr.Header().Get("Cache-Control"); got != immutableCacheControl {
+				t.Errorf("Cache-Control = %q, want %q", got, immutableCacheControl)
+			}
+			if !strings.HasPrefix(rr.Body.String(), "#!/bin/bash") {
+				t.Error("versioned URL did not return the script")
+			}
+		})
+	}
+}
+
+func TestPinnedScriptMatchesPublishedChecksum(t *testing.T) {
+	handler := newTestApp().routes()
+	want := publishedScriptSHA256(t, handler, "?lang=de")
+
+	req := httptest.NewRequest(http.MethodGet, "http://commit.jaw.dev/v/"+want+"/commit.sh?lang=de", nil)
+	req.Header.Set("User-Agent", "curl/8.0.0")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rr.Code, http.StatusOK)
+	}
+	sum := sha256.Sum256(rr.Body.Bytes())
+	if got := hex.EncodeToString(sum[:]); got != want {
+		t.Errorf("pinned script SHA-256 = %s, want the published %s", got, want)
+	}
+}
+
+// publishedScriptSHA256 returns the commit.sh checksum that
+// /commit.sh.sha256 publishes for the query.
+func publishedScriptSHA256(t *testing.T, handler http.Handler, query string) string {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodGet, "http://commit.jaw.dev/commit.sh.sha256"+query, nil)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("checksum status = %d, want %d", rr.Code, http.StatusOK)
+	}
+	sum, _, _ := strings.Cut(rr.Body.String(), " ")
+	return sum
+}
+
+func TestHandleVersionedScriptRejectsDevVersion(t *testing.T) {
+	defer func(previous string) { version = previous }(version)
+	version = "dev"
+
+	app := newTestApp()
+	req := httptest.NewRequest(http.MethodGet, "http://commit.jaw.dev/v/dev/commit.sh", nil)
+	rr := httptest.NewRecorder()
+
+	app.routes().ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusNotFound {
+		t.Fatalf("status = %d, want %d", rr.Code, http.StatusNotFound)
+	}
+}
+
+func TestScriptChecksums(t *testing.T) {
+	for _, tt := range []struct{ name, script, checksum string }{
+		{"commit.sh", "/commit.sh?yes=1", "/commit.sh.sha256?yes=1"},
+		{"install.sh", "/install.sh", "/install.sh.sha256"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApp()
+			handler := app.routes()
+
+			req := httptest.NewRequest(http.MethodGet, "http://commit.jaw.dev"+tt.script, nil)
+			req.Header.Set("User-Agent", "curl/8.0.0")
+			script := httptest.NewRecorder()
+			handler.ServeHTTP(script, req)
+
+			req = httptest.NewRequest(http.MethodGet, "http://commit.jaw.dev"+tt.checksum, nil)
+			req.Header.Set("User-Agent", "curl/8.0.0")
+			checksum := httptest.NewRecorder()
+			handler.ServeHTTP(checksum, req)
+
+			if checksum.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d", checksum.Code, http.StatusOK)
+			}
+			sum := sha256.Sum256(script.Body.Bytes())
+			want := hex.EncodeToString(sum[:]) + "  " + tt.name + "\n"
+			if checksum.Body.String() != want {
+				t.Errorf("checksum = %q, want %q", checksum.Body.String(), want)
+			}
+		})
+	}
+}
+
+func TestScriptSignatures(t *testing.T) {
+	app := newTestApp()
+	app.config.signingKey = newTestSigningKey()
+	handler := app.routes()
+
+	req := httptest.NewRequest(http.MethodGet, "http://commit.jaw.dev/pubkey", nil)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("pubkey status = %d, want %d", rr.Code, http.StatusOK)
+	}
+	block, _ := pem.Decode(rr.Body.Bytes())
+	if block == nil || block.Type != "PUBLIC KEY" {
+		t.Fatalf("pubkey is not a PEM public key:\n%s", rr.Body.String())
+	}
+	parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicKey, ok := parsed.(ed25519.PublicKey)
+	if !ok {
+		t.Fatalf("pubkey type = %T, want ed25519.PublicKey", parsed)
+	}
+
+	for _, name := range []string{"commit.sh", "install.sh"} {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "http://commit.jaw.dev/"+name, nil)
+			req.Header.Set("User-Agent", "curl/8.0.0")
+			script := httptest.NewRecorder()
+			handler.ServeHTTP(script, req)
+
+			req = httptest.NewRequest(http.MethodGet, "http://commit.jaw.dev/"+name+".sig", nil)
+			signature := httptest.NewRecorder()
+			handler.ServeHTTP(signature, req)
+
+			if signature.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d", signature.Code, http.StatusOK)
+			}
+			if !ed25519.Verify(publicKey, script.Body.Bytes(), signature.Body.Bytes()) {
+				t.Error("signature does not verify against the served script")
+			}
+		})
+	}
+}
+
+func TestScriptSignaturesWithoutKey(t *testing.T) {
+	for _, path := range []string{"/pubkey", "/commit.sh.sig", "/install.sh.sig"} {
+		t.Run(path, func(t *testing.T) {
+			app := newTestApp()
+			req := httptest.NewRequest(http.MethodGet, "http://commit.jaw.dev"+path, nil)
+			rr := httptest.NewRecorder()
+
+			app.routes().ServeHTTP(rr, req)
+
+			if rr.Code != http.StatusNotFound {
+				t.Fatalf("status = %d, want %d", rr.Code, http.StatusNotFound)
+			}
+		})
+	}
+}
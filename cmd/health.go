@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+)
+
+// probePaths are answered for any Host, since load balancers and
+// orchestrators usually probe by IP address.
+var probePaths = []string{"/healthz", "/livez", "/readyz"}
+
+// handleLivez reports that the process is up, even while draining.
+func (app *application) handleLivez(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("ok"))
+}
+
+// handleReadyz reports whether the server can serve scripts and pages.
+func (app *application) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain")
+	w.Header().Set("Cache-Control", "no-store")
+
+	if app.draining.Load() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte("draining"))
+		return
+	}
+
+	if err := siteAssets.check(); err != nil {
+		app.logger.Error("readiness check failed", "error", err)
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("ok"))
+}
+
+type scriptVersion struct {
+	// SHA256 is the checksum of the script as /<name>.sha256 serves it to
+	// this host, without presets.
+	SHA256 string `json:"sha256"`
+	// TemplateSHA256 is the checksum of the embedded, unrendered script.
+	TemplateSHA256 string `json:"template_sha256"`
+}
+
+type versionResponse struct {
+	buildInfo
+	Scripts map[string]scriptVersion `json:"scripts"`
+}
+
+func (app *application) handleVersion(w http.ResponseWriter, r *http.Request) {
+	response := versionResponse{
+		buildInfo: readBuildInfo(),
+		Scripts:   make(map[string]scriptVersion, len(scriptFiles)),
+	}
+
+	for name := range scriptFiles {
+		var script bytes.Buffer
+		if err := renderScript(&script, name, scriptData{
+			Domain:   app.domain(r),
+			Version:  response.Version,
+			Revision: response.Revision,
+			Brand:    app.brand(),
+			Relay:    app.config.relayAPIKey != "",
+		}); err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+		templateSHA256, err := siteAssets.scriptSHA256(name)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+		sum := sha256.Sum256(script.Bytes())
+		response.Scripts[name] = scriptVersion{
+			SHA256:         hex.EncodeToString(sum[:]),
+			TemplateSHA256: templateSHA256,
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		app.reportServerError(r, err)
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestAssetsCheck(t *testing.T) {
+	if err := siteAssets.check(); err != nil {
+		t.Errorf("check() = %v, want nil", err)
+	}
+}
+
+func TestProbes(t *testing.T) {
+	app := newTestApp()
+	handler := app.routes()
+
+	get := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "http://commit.jaw.dev"+path, nil)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	for _, path := range []string{"/healthz", "/livez", "/readyz"} {
+		if rr := get(path); rr.Code != http.StatusOK || rr.Body.String() != "ok" {
+			t.Errorf("%s = %d %q, want 200 ok", path, rr.Code, rr.Body.String())
+		}
+	}
+
+	app.draining.Store(true)
+
+	if rr := get("/livez"); rr.Code != http.StatusOK {
+		t.Errorf("/livez while draining = %d, want %d", rr.Code, http.StatusOK)
+	}
+	for _, path := range []string{"/healthz", "/readyz"} {
+		if rr := get(path); rr.Code != http.StatusServiceUnavailable {
+			t.Errorf("%s while draining = %d, want %d", path, rr.Code, http.StatusServiceUnavailable)
+		}
+	}
+}
+
+func TestHandleVersion(t *testing.T) {
+	app := newTestApp()
+	handler := app.routes()
+
+	req := httptest.NewRequest(http.MethodGet, "http://commit.jaw.dev/version", nil)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rr.Code, http.StatusOK)
+	}
+	if got := rr.Header().Get("Content-Type"); got != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", got)
+	}
+
+	var response versionResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if response.Version != version {
+		t.Errorf("version = %q, want %q", response.Version, version)
+	}
+	if response.GoVersion != runtime.Version() {
+		t.Errorf("go_version = %q, want %q", response.GoVersion, runtime.Version())
+	}
+
+	for _, name := range []string{"commit.sh", "install.sh"} {
+		script, ok := response.Scripts[name]
+		if !ok {
+			t.Errorf("scripts does not contain %s", name)
+			continue
+		}
+
+		req := httptest.NewRequest(http.MethodGet, "http://commit.jaw.dev/"+name+".sha256", nil)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		if want, _, _ := strings.Cut(rr.Body.String(), " "); script.SHA256 != want {
+			t.Errorf("%s sha256 = %q, want %q from /%s.sha256", name, script.SHA256, want, name)
+		}
+	}
+	if got := response.Scripts["commit.sh"].TemplateSHA256; got != siteAssets.sums["commit.sh"] {
+		t.Errorf("commit.sh template_sha256 = %q, want %q", got, siteAssets.sums["commit.sh"])
+	}
+}
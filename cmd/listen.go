@@ -0,0 +1,133 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+)
+
+// listenFDsStart is the first file descriptor passed by systemd socket
+// activation.
+const listenFDsStart = 3
+
+// listen opens the main listener: the socket passed by systemd when the
+// process is socket activated, a Unix socket for APP_LISTEN=unix:PATH, or TCP
+// on APP_LISTEN or APP_PORT.
+func (app *application) listen() (net.Listener, error) {
+	listener, err := systemdListener()
+	if err != nil || listener != nil {
+		return listener, err
+	}
+
+	if path, ok := strings.CutPrefix(app.config.listen, "unix:"); ok {
+		return listenUnix(path, app.config.socketMode, app.config.socketGroup)
+	}
+	if app.config.listen != "" {
+		return net.Listen("tcp", app.config.listen)
+	}
+	return net.Listen("tcp", fmt.Sprintf(":%d", app.config.appPort))
+}
+
+// systemdListener returns the socket passed by systemd socket activation, or
+// nil when LISTEN_PID does not name this process. The variables are cleared so
+// child processes do not try to use the socket too.
+func systemdListener() (net.Listener, error) {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+
+	fds := os.Getenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	count, err := strconv.Atoi(fds)
+	if err != nil || count < 1 {
+		return nil, fmt.Errorf("LISTEN_FDS: %q is not a positive integer", fds)
+	}
+	if count > 1 {
+		return nil, fmt.Errorf("LISTEN_FDS: expected one socket, got %d", count)
+	}
+
+	file := os.NewFile(listenFDsStart, "LISTEN_FD_3")
+	defer file.Close()
+	listener, err := net.FileListener(file)
+	if err != nil {
+		return nil, fmt.Errorf("using socket from systemd: %w", err)
+	}
+	return listener, nil
+}
+
+// listenUnix listens on a Unix socket at path with the given mode and, when
+// group is set, group ownership. A stale socket left by a previous run is
+// removed; one that still accepts connections is an error.
+func listenUnix(path string, mode fs.FileMode, group string) (net.Listener, error) {
+	if err := removeStaleSocket(path); err != nil {
+		return nil, err
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("setting socket mode: %w", err)
+	}
+	if group != "" {
+		gid, err := lookupGroup(group)
+		if err == nil {
+			err = os.Chown(path, -1, gid)
+		}
+		if err != nil {
+			listener.Close()
+			return nil, fmt.Errorf("setting socket group: %w", err)
+		}
+	}
+	return listener, nil
+}
+
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Mode().Type() != fs.ModeSocket {
+		return fmt.Errorf("%s exists and is not a socket", path)
+	}
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		return fmt.Errorf("%s is already in use", path)
+	}
+	return os.Remove(path)
+}
+
+// lookupGroup returns the ID of a group given by name or number.
+func lookupGroup(group string) (int, error) {
+	if gid, err := strconv.Atoi(group); err == nil {
+		return gid, nil
+	}
+	g, err := user.LookupGroup(group)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(g.Gid)
+}
+
+// parseSocketMode parses an octal permission mode such as "0660".
+func parseSocketMode(value string) (fs.FileMode, error) {
+	mode, err := strconv.ParseUint(value, 8, 32)
+	if err != nil || mode > 0o777 {
+		return 0, fmt.Errorf("%q is not an octal permission mode such as 0660", value)
+	}
+	return fs.FileMode(mode), nil
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func unixClient(path string) *http.Client {
+	return &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", path)
+		},
+	}}
+}
+
+func TestListenUnix(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "commit.sock")
+
+	stale, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	app := newTestApp()
+	app.config.listen = "unix:" + path
+	app.config.socketMode = 0o600
+	listener, err := app.listen()
+	if err != nil {
+		t.Fatalf("listen() with a stale socket: %v", err)
+	}
+	server := &http.Server{Handler: app.routes()}
+	go server.Serve(listener)
+	defer server.Close()
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := info.Mode().Perm(); got != 0o600 {
+		t.Errorf("socket mode = %o, want 600", got)
+	}
+
+	if _, err := listenUnix(path, 0o600, ""); err == nil || !strings.Contains(err.Error(), "already in use") {
+		t.Errorf("listenUnix() on a socket in use = %v, want already in use", err)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, "http://localhost/", nil)
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("X-Forwarded-Proto", "https")
+	req.Header.Set("X-Forwarded-Host", "commit.example.com")
+	resp, err := unixClient(path).Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+	if !strings.Contains(string(body), "https://commit.example.com") {
+		t.Errorf("body = %s, want the origin forwarded by the proxy on the socket", body)
+	}
+}
+
+func TestListenUnixRefusesOtherFiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "commit.sock")
+	if err := os.WriteFile(path, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := listenUnix(path, 0o660, ""); err == nil || !strings.Contains(err.Error(), "not a socket") {
+		t.Errorf("listenUnix() = %v, want not a socket", err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("regular file was removed: %v", err)
+	}
+}
+
+func TestClientIPOverUnixSocket(t *testing.T) {
+	app := newTestApp()
+	req, _ := http.NewRequest(http.MethodGet, "http://localhost/", nil)
+	req.RemoteAddr = "@"
+	req.Header.Set("X-Forwarded-For", "203.0.113.9, 198.51.100.1")
+
+	if got := app.clientIP(req); got != "@" {
+		t.Errorf("clientIP over TCP = %q, want %q", got, "@")
+	}
+
+	ctx := context.WithValue(req.Context(), http.LocalAddrContextKey, &net.UnixAddr{Name: "/run/commit.sock", Net: "unix"})
+	if got := app.clientIP(req.WithContext(ctx)); got != "198.51.100.1" {
+		t.Errorf("clientIP over a Unix socket = %q, want %q", got, "198.51.100.1")
+	}
+}
+
+func TestParseSocketMode(t *testing.T) {
+	if mode, err := parseSocketMode("0660"); err != nil || mode != 0o660 {
+		t.Errorf("parseSocketMode(0660) = %o, %v", mode, err)
+	}
+	for _, value := range []string{"", "660x", "0999", "01777"} {
+		if _, err := parseSocketMode(value); err == nil {
+			t.Errorf("parseSocketMode(%q) error = nil", value)
+		}
+	}
+}
+
+// TestSystemdListener runs the test binary again with a socket as file
+// descriptor 3, the way systemd passes it. The child sets LISTEN_PID to its own
+// PID, since the parent cannot know it before the process starts.
+func TestSystemdListener(t *testing.T) {
+	if os.Getenv("COMMIT_TEST_SYSTEMD_CHILD") == "1" {
+		os.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+		listener, err := systemdListener()
+		if err != nil || listener == nil {
+			t.Fatalf("systemdListener() = %v, %v", listener, err)
+		}
+		if os.Getenv("LISTEN_FDS") != "" {
+			t.Error("LISTEN_FDS was not cleared")
+		}
+		conn, err := listener.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.Write([]byte("activated"))
+		conn.Close()
+		return
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	file, err := listener.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestSystemdListener$")
+	cmd.Env = append(os.Environ(), "COMMIT_TEST_SYSTEMD_CHILD=1", "LISTEN_FDS=1")
+	cmd.ExtraFiles = []*os.File{file}
+	output := new(strings.Builder)
+	cmd.Stdout, cmd.Stderr = output, output
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	reply, _ := io.ReadAll(conn)
+	conn.Close()
+
+	if err := cmd.Wait(); err != nil {
+		t.Fatalf("child failed: %v\n%s", err, output)
+	}
+	if string(reply) != "activated" {
+		t.Errorf("reply = %q, want %q", reply, "activated")
+	}
+}
+
+func TestSystemdListenerIgnoresOtherProcesses(t *testing.T) {
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()+1))
+	t.Setenv("LISTEN_FDS", "1")
+
+	listener, err := systemdListener()
+	if listener != nil || err != nil {
+		t.Errorf("systemdListener() = %v, %v, want nil, nil", listener, err)
+	}
+}
@@ -1,24 +1,89 @@
 package main
 
 import (
+	"flag"
+	"fmt"
 	"log/slog"
 	"os"
 )
 
 func main() {
-	cfg := config{
-		appEnv:  GetString("APP_ENV", "production"),
-		appPort: GetInt("APP_PORT", 80),
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+
+	configFile := flag.String("config", os.Getenv("CONFIG_FILE"), "read settings from a JSON or TOML `file`; environment variables take precedence")
+	printConfig := flag.Bool("print-config", false, "print the effective configuration with secrets redacted and exit")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] [command]\n\nFlags:\n", os.Args[0])
+		flag.PrintDefaults()
+		fmt.Fprintf(flag.CommandLine.Output(), "\n%s", commandUsage)
 	}
+	flag.Parse()
 
-	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	if *configFile != "" {
+		values, err := loadConfigFile(*configFile)
+		if err != nil {
+			logger.Error("invalid config file", "error", err)
+			os.Exit(1)
+		}
+		env = newSettings(values)
+	}
+
+	cfg, err := loadConfig()
+	if *printConfig {
+		env.printConfig(os.Stdout)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid configuration:\n%v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if err != nil {
+		logger.Error("invalid configuration", "error", err)
+		os.Exit(1)
+	}
+
+	if flag.NArg() > 0 {
+		if err := runCommand(cfg, flag.Args(), os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if cfg.assetsDir != "" {
+		siteAssets, err = newAssetSource(os.DirFS(cfg.assetsDir), true)
+		if err != nil {
+			logger.Error("invalid ASSETS_DIR", "error", err)
+			os.Exit(1)
+		}
+		logger.Info("serving assets from disk", "dir", cfg.assetsDir)
+	}
 
 	app := &application{
 		config: cfg,
 		logger: logger,
 	}
+	if cfg.metricsAddr != "" || cfg.metricsToken != "" {
+		app.metrics = newMetrics()
+	}
+	if cfg.authDB != "" {
+		app.tokens, err = openTokenStore(cfg.authDB)
+		if err != nil {
+			logger.Error("invalid AUTH_DB", "error", err)
+			os.Exit(1)
+		}
+		defer app.tokens.Close()
+	}
+	if cfg.auditLog != "" {
+		app.audit, err = openAuditLog(cfg.auditLog, cfg.auditLogDiffs)
+		if err != nil {
+			logger.Error("invalid AUDIT_LOG", "error", err)
+			os.Exit(1)
+		}
+		defer app.audit.Close()
+	}
 
-	err := app.serve()
+	err = app.serve()
 	if err != nil {
 		app.logger.Error("server failed", "error", err)
 		os.Exit(1)
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"cmp"
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	durationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+	sizeBuckets     = []float64{256, 1024, 4096, 16384, 65536, 262144, 1048576}
+)
+
+type requestKey struct {
+	route  string
+	status int
+	client string
+}
+
+// metrics collects HTTP request metrics and writes them in the Prometheus
+// text exposition format.
+type metrics struct {
+	mu            sync.Mutex
+	requests      map[requestKey]uint64
+	durations     map[string]*histogram
+	responseSizes map[string]*histogram
+}
+
+type histogram struct {
+	buckets []float64
+	counts  []uint64
+	sum     float64
+	count   uint64
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		requests:      make(map[requestKey]uint64),
+		durations:     make(map[string]*histogram),
+		responseSizes: make(map[string]*histogram),
+	}
+}
+
+func newHistogram(buckets []float64) *histogram {
+	return &histogram{buckets: buckets, counts: make([]uint64, len(buckets))}
+}
+
+func (h *histogram) observe(value float64) {
+	for i, bound := range h.buckets {
+		if value <= bound {
+			h.counts[i]++
+		}
+	}
+	h.sum += value
+	h.count++
+}
+
+func (m *metrics) observe(route string, status int, client string, bytes int, duration time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.requests[requestKey{route: route, status: status, client: client}]++
+
+	if m.durations[route] == nil {
+		m.durations[route] = newHistogram(durationBuckets)
+		m.responseSizes[route] = newHistogram(sizeBuckets)
+	}
+	m.durations[route].observe(duration.Seconds())
+	m.responseSizes[route].observe(float64(bytes))
+}
+
+func (m *metrics) writeTo(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	fmt.Fprintln(w, "# HELP commit_http_requests_total Total HTTP requests by route, status and client.")
+	fmt.Fprintln(w, "# TYPE commit_http_requests_total counter")
+	keys := slices.SortedFunc(maps.Keys(m.requests), func(a, b requestKey) int {
+		return cmp.Or(strings.Compare(a.route, b.route), cmp.Compare(a.status, b.status), strings.Compare(a.client, b.client))
+	})
+	for _, key := range keys {
+		fmt.Fprintf(w, "commit_http_requests_total{route=%s,status=\"%d\",client=%s} %d\n",
+			quoteLabel(key.route), key.status, quoteLabel(key.client), m.requests[key])
+	}
+
+	writeHistograms(w, "commit_http_request_duration_seconds", "HTTP request latency by route.", m.durations)
+	writeHistograms(w, "commit_http_response_size_bytes", "HTTP response body size by route.", m.responseSizes)
+}
+
+func writeHistograms(w io.Writer, name, help string, histograms map[string]*histogram) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s histogram\n", name)
+	for _, route := range slices.Sorted(maps.Keys(histograms)) {
+		h := histograms[route]
+		label := quoteLabel(route)
+		for i, bound := range h.buckets {
+			fmt.Fprintf(w, "%s_bucket{route=%s,le=\"%s\"} %d\n", name, label, formatFloat(bound), h.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket{route=%s,le=\"+Inf\"} %d\n", name, label, h.count)
+		fmt.Fprintf(w, "%s_sum{route=%s} %s\n", name, label, formatFloat(h.sum))
+		fmt.Fprintf(w, "%s_count{route=%s} %d\n", name, label, h.count)
+	}
+}
+
+func quoteLabel(value string) string {
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	value = strings.ReplaceAll(value, "\n", `\n`)
+	return `"` + strings.ReplaceAll(value, `"`, `\"`) + `"`
+}
+
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// metricsMiddleware records every request. The route label is the ServeMux
+// pattern, so unbounded paths do not create new series.
+func (app *application) metricsMiddleware(next http.Handler) http.Handler {
+	if app.metrics == nil {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w}
+
+		next.ServeHTTP(sw, r)
+
+		route := r.Pattern
+		if route == "" {
+			route = "unmatched"
+		}
+		app.metrics.observe(route, sw.statusCode(), clientKind(r), sw.bytes, time.Since(start))
+	})
+}
+
+func (app *application) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if app.config.metricsToken != "" {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(app.config.metricsToken)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="metrics"`)
+			app.unauthorized(w, r, "A valid metrics token is required")
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	app.metrics.writeTo(w)
+}
+
+// metricsRoutes serves /metrics on the separate METRICS_ADDR listener.
+func (app *application) metricsRoutes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /metrics", app.handleMetrics)
+	return mux
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetricsExposition(t *testing.T) {
+	m := newMetrics()
+	m.observe("GET /", http.StatusOK, "curl", 2000, 20*time.Millisecond)
+	m.observe("GET /", http.StatusOK, "curl", 100, 2*time.Second)
+	m.observe("GET /", http.StatusNotFound, "browser", 300, time.Millisecond)
+	m.observe(`GET /"quoted"`, http.StatusOK, "json", 0, 0)
+
+	var out bytes.Buffer
+	m.writeTo(&out)
+
+	for _, want := range []string{
+		"# TYPE commit_http_requests_total counter",
+		`commit_http_requests_total{route="GET /",status="200",client="curl"} 2`,
+		`commit_http_requests_total{route="GET /",status="404",client="browser"} 1`,
+		`commit_http_requests_total{route="GET /\"quoted\"",status="200",client="json"} 1`,
+		"# TYPE commit_http_request_duration_seconds histogram",
+		`commit_http_request_duration_seconds_bucket{route="GET /",le="0.005"} 1`,
+		`commit_http_request_duration_seconds_bucket{route="GET /",le="0.025"} 2`,
+		`commit_http_request_duration_seconds_bucket{route="GET /",le="2.5"} 3`,
+		`commit_http_request_duration_seconds_bucket{route="GET /",le="+Inf"} 3`,
+		`commit_http_request_duration_seconds_count{route="GET /"} 3`,
+		"# TYPE commit_http_response_size_bytes histogram",
+		`commit_http_response_size_bytes_bucket{route="GET /",le="256"} 1`,
+		`commit_http_response_size_bytes_bucket{route="GET /",le="1024"} 2`,
+		`commit_http_response_size_bytes_sum{route="GET /"} 2400`,
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("metrics do not contain %q", want)
+		}
+	}
+}
+
+func TestMetricsEndpointRequiresToken(t *testing.T) {
+	app := newTestApp()
+	app.config.metricsToken = "secret"
+	app.metrics = newMetrics()
+	handler := app.routes()
+
+	req := httptest.NewRequest(http.MethodGet, "http://commit.jaw.dev/", nil)
+	req.Header.Set("User-Agent", "curl/8.0.0")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	tests := []struct {
+		name          string
+		authorization string
+		status        int
+	}{
+		{"missing token", "", http.StatusUnauthorized},
+		{"wrong token", "Bearer wrong", http.StatusUnauthorized},
+		{"wrong scheme", "Basic secret", http.StatusUnauthorized},
+		{"valid token", "Bearer secret", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "http://commit.jaw.dev/metrics", nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			rr := httptest.NewRecorder()
+
+			handler.ServeHTTP(rr, req)
+
+			if rr.Code != tt.status {
+				t.Fatalf("status = %d, want %d", rr.Code, tt.status)
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+			want := `commit_http_requests_total{route="GET /",status="200",client="curl"} 1`
+			if !strings.Contains(rr.Body.String(), want) {
+				t.Errorf("metrics do not contain %q:\n%s", want, rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestMetricsEndpointDisabledByDefault(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		cfg  config
+	}{
+		{"no configuration", config{}},
+		{"separate listener", config{metricsAddr: "127.0.0.1:9090", metricsToken: "secret"}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApp()
+			app.config = tt.cfg
+			app.metrics = newMetrics()
+			req := httptest.NewRequest(http.MethodGet, "http://commit.jaw.dev/metrics", nil)
+			req.Header.Set("Authorization", "Bearer secret")
+			rr := httptest.NewRecorder()
+
+			app.routes().ServeHTTP(rr, req)
+
+			if rr.Code != http.StatusNotFound {
+				t.Fatalf("status = %d, want %d", rr.Code, http.StatusNotFound)
+			}
+		})
+	}
+}
@@ -1,10 +1,26 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
 	"net/http"
+	"regexp"
 	"strings"
+	"time"
 )
 
+type contextKey string
+
+const (
+	requestIDKey contextKey = "requestID"
+	cspNonceKey  contextKey = "cspNonce"
+)
+
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,128}$`)
+
 func (app *application) stripTrailingSlashMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if strings.HasSuffix(r.URL.Path, "/") && r.URL.Path != "/static/" {
@@ -14,3 +30,251 @@ func (app *application) stripTrailingSlashMiddleware(next http.Handler) http.Han
 		next.ServeHTTP(w, r)
 	})
 }
+
+// staticETagMiddleware sets the ETag of static files so that
+// http.FileServer can answer conditional requests with 304 Not Modified.
+func (app *application) staticETagMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if etag, ok := siteAssets.staticETag(strings.TrimPrefix(r.URL.Path, "/")); ok {
+			w.Header().Set("ETag", etag)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// statusWriter records the status code and body size written by a handler.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (sw *statusWriter) WriteHeader(statusCode int) {
+	if sw.status == 0 {
+		sw.status = statusCode
+	}
+	sw.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (sw *statusWriter) Write(b []byte) (int, error) {
+	if sw.status == 0 {
+		sw.status = http.StatusOK
+	}
+	n, err := sw.ResponseWriter.Write(b)
+	sw.bytes += n
+	return n, err
+}
+
+func (sw *statusWriter) Unwrap() http.ResponseWriter {
+	return sw.ResponseWriter
+}
+
+func (sw *statusWriter) statusCode() int {
+	if sw.status == 0 {
+		return http.StatusOK
+	}
+	return sw.status
+}
+
+// requestIDMiddleware keeps a well-formed incoming X-Request-ID or generates a
+// new one, echoes it in the response and stores it in the request context.
+func (app *application) requestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !requestIDPattern.MatchString(id) {
+			id = newRequestID()
+		}
+
+		w.Header().Set("X-Request-ID", id)
+		ctx := context.WithValue(r.Context(), requestIDKey, id)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func requestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDKey).(string)
+	return id
+}
+
+// recoverPanicMiddleware turns a panic into a 500 response and an error log
+// with the stack trace. When the handler had already sent its headers, the
+// response is aborted instead, since the status can no longer change.
+// http.ErrAbortHandler is re-raised so the server can abort the response as
+// intended.
+func (app *application) recoverPanicMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sw := &statusWriter{ResponseWriter: w}
+
+		defer func() {
+			recovered := recover()
+			if recovered == nil {
+				return
+			}
+			if recovered == http.ErrAbortHandler {
+				panic(recovered)
+			}
+
+			err := fmt.Errorf("panic: %v", recovered)
+			if sw.status != 0 {
+				app.reportServerError(r, err)
+				panic(http.ErrAbortHandler)
+			}
+
+			// Drop what the handler prepared for its own response, so the
+			// error is not cached or downloaded like a script.
+			header := w.Header()
+			for _, name := range []string{"Cache-Control", "ETag", "Content-Disposition", "Content-Type"} {
+				header.Del(name)
+			}
+			header.Set("Connection", "close")
+			app.serverError(w, r, err)
+		}()
+
+		next.ServeHTTP(sw, r)
+	})
+}
+
+// securityHeadersMiddleware sets headers that apply to every response and a
+// Content-Security-Policy with a per-response nonce on HTML pages. Templates
+// read the nonce from the request context through cspNonce.
+func (app *application) securityHeadersMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		nonce := newCSPNonce()
+
+		header := w.Header()
+		header.Set("X-Content-Type-Options", "nosniff")
+		header.Set("Referrer-Policy", "no-referrer")
+		header.Set("Permissions-Policy", "camera=(), geolocation=(), microphone=(), payment=(), usb=()")
+		if app.config.appEnv == "production" {
+			header.Set("Strict-Transport-Security", "max-age=63072000; includeSubDomains")
+		}
+
+		hw := &htmlHeaderWriter{ResponseWriter: w, policy: contentSecurityPolicy(nonce, app.brand().analyticsOrigin())}
+		ctx := context.WithValue(r.Context(), cspNonceKey, nonce)
+		next.ServeHTTP(hw, r.WithContext(ctx))
+	})
+}
+
+// contentSecurityPolicy allows only nonce-bearing scripts and styles, and
+// connections to the analytics origin when analytics is on.
+func contentSecurityPolicy(nonce, connectSrc string) string {
+	policy := fmt.Sprintf("default-src 'none'; script-src 'nonce-%[1]s'; style-src 'nonce-%[1]s'; img-src 'self'; ", nonce)
+	if connectSrc != "" {
+		policy += "connect-src " + connectSrc + "; "
+	}
+	return policy + "base-uri 'none'; form-action 'none'; frame-ancestors 'none'"
+}
+
+func newCSPNonce() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func cspNonce(r *http.Request) string {
+	nonce, _ := r.Context().Value(cspNonceKey).(string)
+	return nonce
+}
+
+// htmlHeaderWriter adds the Content-Security-Policy and frame headers once the
+// handler has declared an HTML response.
+type htmlHeaderWriter struct {
+	http.ResponseWriter
+	policy      string
+	wroteHeader bool
+}
+
+func (hw *htmlHeaderWriter) WriteHeader(statusCode int) {
+	if !hw.wroteHeader {
+		hw.wroteHeader = true
+		header := hw.Header()
+		if strings.HasPrefix(header.Get("Content-Type"), "text/html") {
+			header.Set("Content-Security-Policy", hw.policy)
+			header.Set("X-Frame-Options", "DENY")
+		}
+	}
+	hw.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (hw *htmlHeaderWriter) Write(b []byte) (int, error) {
+	if !hw.wroteHeader {
+		if hw.Header().Get("Content-Type") == "" {
+			hw.Header().Set("Content-Type", http.DetectContentType(b))
+		}
+		hw.WriteHeader(http.StatusOK)
+	}
+	return hw.ResponseWriter.Write(b)
+}
+
+func (hw *htmlHeaderWriter) Unwrap() http.ResponseWriter {
+	return hw.ResponseWriter
+}
+
+func (app *application) logRequestMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w}
+
+		next.ServeHTTP(sw, r)
+
+		app.logger.Info("request",
+			"request_id", requestID(r),
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", sw.statusCode(),
+			"bytes", sw.bytes,
+			"duration_ms", float64(time.Since(start).Microseconds())/1000,
+			"client", clientKind(r),
+			"remote_ip", app.clientIP(r),
+		)
+	})
+}
+
+// muxErrorMiddleware replaces the plain-text 404 and 405 responses ServeMux
+// writes for requests that match no pattern with the application's own error
+// responses. The Allow header ServeMux sets is kept.
+func (app *application) muxErrorMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mw := &muxErrorWriter{ResponseWriter: w, request: r}
+
+		next.ServeHTTP(mw, r)
+
+		switch mw.intercepted {
+		case http.StatusNotFound:
+			app.notFound(w, r)
+		case http.StatusMethodNotAllowed:
+			app.methodNotAllowed(w, r)
+		}
+	})
+}
+
+type muxErrorWriter struct {
+	http.ResponseWriter
+	request     *http.Request
+	intercepted int
+}
+
+func (mw *muxErrorWriter) WriteHeader(statusCode int) {
+	if mw.request.Pattern == "" && (statusCode == http.StatusNotFound || statusCode == http.StatusMethodNotAllowed) {
+		mw.intercepted = statusCode
+		return
+	}
+	mw.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (mw *muxErrorWriter) Write(b []byte) (int, error) {
+	if mw.intercepted != 0 {
+		return len(b), nil
+	}
+	return mw.ResponseWriter.Write(b)
+}
+
+func (mw *muxErrorWriter) Unwrap() http.ResponseWriter {
+	return mw.ResponseWriter
+}
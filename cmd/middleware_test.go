@@ -0,0 +1,409 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRequestIDMiddleware(t *testing.T) {
+	tests := []struct {
+		name     string
+		incoming string
+		keep     bool
+	}{
+		{"generates missing id", "", false},
+		{"keeps valid id", "trace-123.abc_DEF", true},
+		{"replaces id with spaces", "bad id", false},
+		{"replaces id with control characters", "bad\nid", false},
+		{"replaces oversized id", strings.Repeat("a", 129), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApp()
+			var seen string
+			handler := app.requestIDMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				seen = requestID(r)
+			}))
+
+			req := httptest.NewRequest(http.MethodGet, "http://commit.jaw.dev/", nil)
+			if tt.incoming != "" {
+				req.Header.Set("X-Request-ID", tt.incoming)
+			}
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			got := rr.Header().Get("X-Request-ID")
+			if got != seen {
+				t.Errorf("response id = %q, context id = %q", got, seen)
+			}
+			if tt.keep && got != tt.incoming {
+				t.Errorf("id = %q, want %q", got, tt.incoming)
+			}
+			if !tt.keep && (got == tt.incoming || len(got) != 32) {
+				t.Errorf("id = %q, want a generated id", got)
+			}
+		})
+	}
+}
+
+func TestAccessLog(t *testing.T) {
+	var logs bytes.Buffer
+	app := newTestApp()
+	app.logger = slog.New(slog.NewJSONHandler(&logs, nil))
+
+	req := httptest.NewRequest(http.MethodGet, "http://commit.jaw.dev/robots.txt", nil)
+	req.Header.Set("User-Agent", "curl/8.0.0")
+	req.Header.Set("X-Request-ID", "access-log-test")
+	req.RemoteAddr = "192.0.2.10:54321"
+	rr := httptest.NewRecorder()
+
+	app.routes().ServeHTTP(rr, req)
+
+	var entry struct {
+		Msg       string  `json:"msg"`
+		RequestID string  `json:"request_id"`
+		Method    string  `json:"method"`
+		Path      string  `json:"path"`
+		Status    int     `json:"status"`
+		Bytes     int     `json:"bytes"`
+		Duration  float64 `json:"duration_ms"`
+		Client    string  `json:"client"`
+		RemoteIP  string  `json:"remote_ip"`
+	}
+	if err := json.Unmarshal(logs.Bytes(), &entry); err != nil {
+		t.Fatalf("access log is not a JSON line: %v\n%s", err, logs.String())
+	}
+	for _, field := range []struct {
+		name      string
+		got, want any
+	}{
+		{"msg", entry.Msg, "request"},
+		{"request_id", entry.RequestID, "access-log-test"},
+		{"method", entry.Method, http.MethodGet},
+		{"path", entry.Path, "/robots.txt"},
+		{"status", entry.Status, http.StatusOK},
+		{"bytes", entry.Bytes, rr.Body.Len()},
+		{"client", entry.Client, "curl"},
+		{"remote_ip", entry.RemoteIP, "192.0.2.10"},
+	} {
+		if field.got != field.want {
+			t.Errorf("%s = %v, want %v", field.name, field.got, field.want)
+		}
+	}
+	if entry.Duration < 0 {
+		t.Errorf("duration_ms = %v", entry.Duration)
+	}
+}
+
+func TestServerErrorIncludesRequestID(t *testing.T) {
+	var logs bytes.Buffer
+	app := newTestApp()
+	app.logger = slog.New(slog.NewJSONHandler(&logs, nil))
+	handler := app.requestIDMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		app.serverError(w, r, errors.New("boom"))
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "http://commit.jaw.dev/", nil)
+	req.Header.Set("X-Request-ID", "server-error-test")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	var entry struct {
+		Msg     string `json:"msg"`
+		Request struct {
+			ID string `json:"id"`
+		} `json:"request"`
+		Trace string `json:"trace"`
+	}
+	if err := json.Unmarshal(logs.Bytes(), &entry); err != nil {
+		t.Fatal(err)
+	}
+	if entry.Msg != "boom" || entry.Request.ID != "server-error-test" || entry.Trace == "" {
+		t.Errorf("unexpected error log: %s", logs.String())
+	}
+}
+
+func TestRecoverPanicMiddleware(t *testing.T) {
+	tests := []struct {
+		name        string
+		accept      string
+		contentType string
+		want        string
+	}{
+		{"html", "text/html", "text/html; charset=utf-8", "<h1>500</h1>"},
+		{"json", "application/json", "application/json", `"message":"The server encountered a problem`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var logs bytes.Buffer
+			app := newTestApp()
+			app.logger = slog.New(slog.NewJSONHandler(&logs, nil))
+
+			mux := http.NewServeMux()
+			mux.HandleFunc("GET /panic", func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Cache-Control", immutableCacheControl)
+				w.Header().Set("ETag", `"script"`)
+				w.Header().Set("Content-Disposition", "attachment; filename=commit.sh")
+				w.Header().Set("Content-Type", "text/plain")
+				panic("handler exploded")
+			})
+			handler := app.requestIDMiddleware(app.logRequestMiddleware(app.recoverPanicMiddleware(mux)))
+
+			req := httptest.NewRequest(http.MethodGet, "http://commit.jaw.dev/panic", nil)
+			req.Header.Set("Accept", tt.accept)
+			req.Header.Set("X-Request-ID", "panic-test")
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			if rr.Code != http.StatusInternalServerError {
+				t.Fatalf("status = %d, want %d", rr.Code, http.StatusInternalServerError)
+			}
+			if got := rr.Header().Get("Connection"); got != "close" {
+				t.Errorf("Connection = %q, want close", got)
+			}
+			if got := rr.Header().Get("Content-Type"); got != tt.contentType {
+				t.Errorf("Content-Type = %q, want %q", got, tt.contentType)
+			}
+			for _, header := range []string{"Cache-Control", "ETag", "Content-Disposition"} {
+				if got := rr.Header().Get(header); got != "" {
+					t.Errorf("%s = %q, want the handler's value dropped", header, got)
+				}
+			}
+			if !strings.Contains(rr.Body.String(), tt.want) {
+				t.Errorf("response does not contain %q:\n%s", tt.want, rr.Body.String())
+			}
+			if strings.Contains(rr.Body.String(), "handler exploded") {
+				t.Error("response leaks the panic value")
+			}
+
+			lines := strings.Split(strings.TrimSpace(logs.String()), "\n")
+			if len(lines) != 2 {
+				t.Fatalf("want an error log and an access log, got:\n%s", logs.String())
+			}
+			var errorLog struct {
+				Msg     string `json:"msg"`
+				Request struct {
+					ID string `json:"id"`
+				} `json:"request"`
+				Trace string `json:"trace"`
+			}
+			if err := json.Unmarshal([]byte(lines[0]), &errorLog); err != nil {
+				t.Fatal(err)
+			}
+			if errorLog.Msg != "panic: handler exploded" || errorLog.Request.ID != "panic-test" {
+				t.Errorf("unexpected error log: %s", lines[0])
+			}
+			if !strings.Contains(errorLog.Trace, "TestRecoverPanicMiddleware") {
+				t.Error("error log does not contain the panicking stack")
+			}
+			var accessLog struct {
+				Status int `json:"status"`
+			}
+			if err := json.Unmarshal([]byte(lines[1]), &accessLog); err != nil {
+				t.Fatal(err)
+			}
+			if accessLog.Status != http.StatusInternalServerError {
+				t.Errorf("access log status = %d, want %d", accessLog.Status, http.StatusInternalServerError)
+			}
+		})
+	}
+}
+
+func TestRecoverPanicMiddlewareRepanicsAbortHandler(t *testing.T) {
+	app := newTestApp()
+	handler := app.recoverPanicMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic(http.ErrAbortHandler)
+	}))
+
+	defer func() {
+		if recovered := recover(); recovered != http.ErrAbortHandler {
+			t.Errorf("recovered = %v, want http.ErrAbortHandler", recovered)
+		}
+	}()
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://commit.jaw.dev/", nil))
+}
+
+func TestRecoverPanicMiddlewareAbortsStartedResponse(t *testing.T) {
+	var logs bytes.Buffer
+	app := newTestApp()
+	app.logger = slog.New(slog.NewJSONHandler(&logs, nil))
+	handler := app.recoverPanicMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("partial"))
+		panic("handler exploded")
+	}))
+	rr := httptest.NewRecorder()
+
+	func() {
+		defer func() {
+			if recovered := recover(); recovered != http.ErrAbortHandler {
+				t.Errorf("recovered = %v, want http.ErrAbortHandler", recovered)
+			}
+		}()
+		handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "http://commit.jaw.dev/", nil))
+	}()
+
+	if rr.Code != http.StatusOK || rr.Body.String() != "partial" {
+		t.Errorf("response = %d %q, want the handler's 200 %q untouched", rr.Code, rr.Body.String(), "partial")
+	}
+	if !strings.Contains(logs.String(), "panic: handler exploded") {
+		t.Errorf("panic was not logged:\n%s", logs.String())
+	}
+}
+
+func TestSecurityHeaders(t *testing.T) {
+	tests := []struct {
+		name      string
+		path      string
+		userAgent string
+		wantCSP   bool
+	}{
+		{"home page", "/", "Mozilla/5.0", true},
+		{"error page", "/missing", "Mozilla/5.0", true},
+		{"commit script", "/", "curl/8.0.0", false},
+		{"install script", "/install.sh", "curl/8.0.0", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApp()
+			app.config.brand = defaultBranding
+			app.config.brand.AnalyticsScriptURL = "https://stats.example.com/script.js"
+			req := httptest.NewRequest(http.MethodGet, "http://commit.jaw.dev"+tt.path, nil)
+			req.Header.Set("User-Agent", tt.userAgent)
+			rr := httptest.NewRecorder()
+
+			app.routes().ServeHTTP(rr, req)
+
+			for header, want := range map[string]string{
+				"X-Content-Type-Options": "nosniff",
+				"Referrer-Policy":        "no-referrer",
+			} {
+				if got := rr.Header().Get(header); got != want {
+					t.Errorf("%s = %q, want %q", header, got, want)
+				}
+			}
+			if rr.Header().Get("Permissions-Policy") == "" {
+				t.Error("Permissions-Policy is not set")
+			}
+
+			csp := rr.Header().Get("Content-Security-Policy")
+			if !tt.wantCSP {
+				if csp != "" {
+					t.Errorf("non-HTML response has Content-Security-Policy %q", csp)
+				}
+				return
+			}
+			if !strings.Contains(csp, "default-src 'none'") || !strings.Contains(csp, "frame-ancestors 'none'") {
+				t.Errorf("Content-Security-Policy = %q, want a strict policy", csp)
+			}
+			_, rest, ok := strings.Cut(csp, "script-src 'nonce-")
+			nonce, _, _ := strings.Cut(rest, "'")
+			if !ok || nonce == "" {
+				t.Fatalf("Content-Security-Policy has no script nonce: %q", csp)
+			}
+			if !strings.Contains(rr.Body.String(), `nonce="`+nonce+`"`) {
+				t.Error("page scripts do not carry the response nonce")
+			}
+			if !strings.Contains(csp, "connect-src https://stats.example.com;") {
+				t.Errorf("Content-Security-Policy = %q, want the analytics origin in connect-src", csp)
+			}
+		})
+	}
+}
+
+func TestAnalytics(t *testing.T) {
+	tests := []struct {
+		name        string
+		scriptURL   string
+		websiteID   string
+		wantTag     string
+		wantConnect string
+	}{
+		{"default", defaultBranding.AnalyticsScriptURL, defaultBranding.AnalyticsWebsiteID, `src="https://umami.jaw.dev/script.js" data-website-id="523275d2-6964-4ccd-a40f-d6bec6b12c77">`, "https://umami.jaw.dev"},
+		{"off", "", "", "", ""},
+		{"script only", "https://stats.example.com/script.js", "", `src="https://stats.example.com/script.js">`, "https://stats.example.com"},
+		{"script and website", "https://stats.example.com:8443/u.js", "site-1", `src="https://stats.example.com:8443/u.js" data-website-id="site-1">`, "https://stats.example.com:8443"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApp()
+			app.config.brand = defaultBranding
+			app.config.brand.AnalyticsScriptURL = tt.scriptURL
+			app.config.brand.AnalyticsWebsiteID = tt.websiteID
+			req := httptest.NewRequest(http.MethodGet, "http://commit.jaw.dev/", nil)
+			req.Header.Set("User-Agent", "Mozilla/5.0")
+			rr := httptest.NewRecorder()
+
+			app.routes().ServeHTTP(rr, req)
+
+			body := rr.Body.String()
+			csp := rr.Header().Get("Content-Security-Policy")
+			if tt.wantTag == "" {
+				if strings.Contains(body, "<script") {
+					t.Errorf("page has a script tag with analytics off:\n%s", body)
+				}
+				if strings.Contains(csp, "connect-src") {
+					t.Errorf("Content-Security-Policy = %q, want no connect-src", csp)
+				}
+				return
+			}
+			if !strings.Contains(body, tt.wantTag) {
+				t.Errorf("page does not contain %q:\n%s", tt.wantTag, body)
+			}
+			if want := "connect-src " + tt.wantConnect + ";"; !strings.Contains(csp, want) {
+				t.Errorf("Content-Security-Policy = %q, want %q", csp, want)
+			}
+		})
+	}
+}
+
+func TestSecurityHeadersNonceIsPerResponse(t *testing.T) {
+	app := newTestApp()
+	handler := app.routes()
+
+	policies := make(map[string]bool)
+	for range 2 {
+		req := httptest.NewRequest(http.MethodGet, "http://commit.jaw.dev/", nil)
+		req.Header.Set("User-Agent", "Mozilla/5.0")
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		policies[rr.Header().Get("Content-Security-Policy")] = true
+	}
+	if len(policies) != 2 {
+		t.Error("responses share a Content-Security-Policy nonce")
+	}
+}
+
+func TestSecurityHeadersHSTS(t *testing.T) {
+	for _, tt := range []struct {
+		appEnv string
+		want   bool
+	}{
+		{"production", true},
+		{"development", false},
+		{"testing", false},
+	} {
+		t.Run(tt.appEnv, func(t *testing.T) {
+			app := newTestApp()
+			app.config.appEnv = tt.appEnv
+			req := httptest.NewRequest(http.MethodGet, "http://commit.jaw.dev/healthz", nil)
+			rr := httptest.NewRecorder()
+
+			app.routes().ServeHTTP(rr, req)
+
+			if got := rr.Header().Get("Strict-Transport-Security") != ""; got != tt.want {
+				t.Errorf("HSTS set = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
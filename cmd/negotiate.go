@@ -0,0 +1,161 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Media types a response can be negotiated to.
+const (
+	mediaScript  = "text/x-shellscript"
+	mediaJSON    = "application/json"
+	mediaProblem = "application/problem+json"
+	mediaHTML    = "text/html"
+	mediaText    = "text/plain"
+)
+
+// scriptMediaTypes are the Accept values that ask for the raw script.
+var scriptMediaTypes = []string{mediaScript, "application/x-sh", "text/x-sh", "application/x-shellscript"}
+
+// cliFetchers maps User-Agent product names to the client label used in logs
+// and metrics. These clients get the script when Accept does not decide.
+var cliFetchers = []struct {
+	product string
+	label   string
+}{
+	{"curl", "curl"},
+	{"wget", "wget"},
+	{"httpie", "httpie"},
+	{"go-http-client", "go"},
+	{"ansible-httpget", "ansible"},
+}
+
+type mediaRange struct {
+	mediaType string
+	quality   float64
+}
+
+// parseAccept parses an Accept header into media ranges in header order.
+// Ranges with an invalid quality are skipped.
+func parseAccept(header string) []mediaRange {
+	var ranges []mediaRange
+	for _, part := range strings.Split(header, ",") {
+		params := strings.Split(part, ";")
+		mediaType := strings.ToLower(strings.TrimSpace(params[0]))
+		if mediaType == "" {
+			continue
+		}
+
+		quality := 1.0
+		valid := true
+		for _, param := range params[1:] {
+			name, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+			if strings.ToLower(strings.TrimSpace(name)) != "q" {
+				continue
+			}
+			q, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+			if err != nil || q < 0 || q > 1 {
+				valid = false
+				break
+			}
+			quality = q
+		}
+		if valid {
+			ranges = append(ranges, mediaRange{mediaType: mediaType, quality: quality})
+		}
+	}
+	return ranges
+}
+
+// acceptQuality returns the quality the client gives mediaType, from the most
+// specific matching range, and whether that range named the type exactly. A
+// missing Accept header accepts everything.
+func acceptQuality(ranges []mediaRange, mediaType string) (float64, bool) {
+	if len(ranges) == 0 {
+		return 1, false
+	}
+
+	mainType, _, _ := strings.Cut(mediaType, "/")
+	quality, specificity := 0.0, -1
+	for _, r := range ranges {
+		var s int
+		switch r.mediaType {
+		case mediaType:
+			s = 2
+		case mainType + "/*":
+			s = 1
+		case "*/*":
+			s = 0
+		default:
+			continue
+		}
+		if s > specificity {
+			quality, specificity = r.quality, s
+		}
+	}
+	return quality, specificity == 2
+}
+
+// cliFetcher returns the label of a known command line client, or "".
+func cliFetcher(r *http.Request) string {
+	product, _, _ := strings.Cut(strings.ToLower(r.Header.Get("User-Agent")), "/")
+	for _, fetcher := range cliFetchers {
+		if product == fetcher.product {
+			return fetcher.label
+		}
+	}
+	return ""
+}
+
+// negotiate picks one of offers for the request. The offer with the highest
+// Accept quality wins, and an offer named exactly beats one matched by a
+// wildcard. Remaining ties go to the script, or plain text where no script is
+// offered, for command line clients and to the first offer otherwise.
+// mediaScript stands for every script media type, and ?raw=1 always selects
+// the script or plain text when offered.
+func negotiate(r *http.Request, offers ...string) string {
+	cliOffer := mediaScript
+	if !slices.Contains(offers, mediaScript) {
+		cliOffer = mediaText
+	}
+	if raw, err := strconv.ParseBool(r.URL.Query().Get("raw")); err == nil && raw && slices.Contains(offers, cliOffer) {
+		return cliOffer
+	}
+
+	ranges := parseAccept(r.Header.Get("Accept"))
+	isCLI := cliFetcher(r) != ""
+
+	best, bestQuality, bestExact := "", 0.0, false
+	for _, offer := range offers {
+		quality, exact := acceptQuality(ranges, offer)
+		if offer == mediaScript {
+			for _, alias := range scriptMediaTypes[1:] {
+				if q, e := acceptQuality(ranges, alias); e && (!exact || q > quality) {
+					quality, exact = q, e
+				}
+			}
+		}
+		if quality == 0 {
+			continue
+		}
+
+		better := quality > bestQuality ||
+			(quality == bestQuality && exact && !bestExact) ||
+			(quality == bestQuality && exact == bestExact && offer == cliOffer && isCLI)
+		if best == "" || better {
+			best, bestQuality, bestExact = offer, quality, exact
+		}
+	}
+
+	if best == "" {
+		return offers[0]
+	}
+	return best
+}
+
+// varyNegotiated marks a response as depending on the headers negotiate reads.
+func varyNegotiated(w http.ResponseWriter) {
+	w.Header().Add("Vary", "Accept, User-Agent")
+}
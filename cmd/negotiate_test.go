@@ -0,0 +1,128 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNegotiate(t *testing.T) {
+	const browserAccept = "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8"
+
+	tests := []struct {
+		name      string
+		target    string
+		userAgent string
+		accept    string
+		want      string
+	}{
+		{"browser", "/", "Mozilla/5.0", browserAccept, mediaHTML},
+		{"curl", "/", "curl/8.0.0", "*/*", mediaScript},
+		{"wget", "/", "Wget/1.21.4", "*/*", mediaScript},
+		{"httpie", "/", "HTTPie/3.2.2", "*/*", mediaScript},
+		{"go http client", "/", "Go-http-client/1.1", "", mediaScript},
+		{"ansible", "/", "ansible-httpget", "", mediaScript},
+		{"unknown client", "/", "python-requests/2.31", "*/*", mediaHTML},
+		{"shellscript accept", "/", "python-requests/2.31", "text/x-shellscript", mediaScript},
+		{"x-sh alias", "/", "", "application/x-sh", mediaScript},
+		{"json", "/", "Mozilla/5.0", "application/json", mediaJSON},
+		{"curl asking for json", "/", "curl/8.0.0", "application/json", mediaJSON},
+		{"json preferred by quality", "/", "", "text/html;q=0.5, application/json", mediaJSON},
+		{"html excluded", "/", "curl/8.0.0", "text/html;q=0, */*", mediaScript},
+		{"exact beats wildcard", "/", "curl/8.0.0", "text/*, text/html", mediaHTML},
+		{"nothing acceptable", "/", "", "image/png", mediaHTML},
+		{"invalid quality ignored", "/", "", "application/json;q=2, text/html", mediaHTML},
+		{"raw override", "/?raw=1", "Mozilla/5.0", browserAccept, mediaScript},
+		{"raw false", "/?raw=false", "Mozilla/5.0", browserAccept, mediaHTML},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "http://commit.jaw.dev"+tt.target, nil)
+			req.Header.Set("User-Agent", tt.userAgent)
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+
+			if got := negotiate(req, mediaHTML, mediaJSON, mediaScript); got != tt.want {
+				t.Errorf("negotiate() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNegotiatePlainText(t *testing.T) {
+	tests := []struct {
+		name      string
+		target    string
+		userAgent string
+		accept    string
+		want      string
+	}{
+		{"browser", "/", "Mozilla/5.0", "text/html,*/*;q=0.8", mediaHTML},
+		{"curl", "/", "curl/8.0.0", "*/*", mediaText},
+		{"unknown client", "/", "python-requests/2.31", "*/*", mediaHTML},
+		{"text accept", "/", "python-requests/2.31", "text/plain", mediaText},
+		{"raw override", "/?raw=1", "Mozilla/5.0", "text/html", mediaText},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "http://commit.jaw.dev"+tt.target, nil)
+			req.Header.Set("User-Agent", tt.userAgent)
+			req.Header.Set("Accept", tt.accept)
+
+			if got := negotiate(req, mediaHTML, mediaJSON, mediaText); got != tt.want {
+				t.Errorf("negotiate() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHomeServesScriptToCLIFetchers(t *testing.T) {
+	app := newTestApp()
+	handler := app.routes()
+
+	for _, userAgent := range []string{"Wget/1.21.4", "HTTPie/3.2.2", "Go-http-client/1.1", "ansible-httpget"} {
+		for _, path := range []string{"/", "/install.sh"} {
+			req := httptest.NewRequest(http.MethodGet, "http://commit.jaw.dev"+path, nil)
+			req.Header.Set("User-Agent", userAgent)
+			rr := httptest.NewRecorder()
+
+			handler.ServeHTTP(rr, req)
+
+			if rr.Code != http.StatusOK || !strings.HasPrefix(rr.Body.String(), "#!/") {
+				t.Errorf("%s %s: status %d, body does not start with a shebang", userAgent, path, rr.Code)
+			}
+			if got := rr.Header().Get("Vary"); got != "Accept, User-Agent" {
+				t.Errorf("%s %s: Vary = %q, want Accept, User-Agent", userAgent, path, got)
+			}
+		}
+	}
+}
+
+func TestHomeRawOverride(t *testing.T) {
+	app := newTestApp()
+
+	req := httptest.NewRequest(http.MethodGet, "http://commit.jaw.dev/?raw=1&model=openrouter/auto", nil)
+	req.Header.Set("User-Agent", "Mozilla/5.0")
+	rr := httptest.NewRecorder()
+	app.routes().ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rr.Code, http.StatusOK)
+	}
+	if !strings.Contains(rr.Body.String(), "PRESET_MODEL='openrouter/auto'") {
+		t.Error("raw response does not contain the script with presets")
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "http://commit.jaw.dev/?raw=0&model=openrouter/auto", nil)
+	req.Header.Set("User-Agent", "Mozilla/5.0")
+	rr = httptest.NewRecorder()
+	app.routes().ServeHTTP(rr, req)
+
+	if body := rr.Body.String(); strings.Contains(body, "raw=") || !strings.Contains(body, "model=openrouter") {
+		t.Error("page command should keep presets but drop raw")
+	}
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+var hostPattern = regexp.MustCompile(`^([A-Za-z0-9-]+(\.[A-Za-z0-9-]+)*\.?|\[[0-9A-Fa-f:.]+\])(:[0-9]{1,5})?$`)
+
+// domain returns the origin embedded in served scripts and pages, e.g.
+// "https://commit.jaw.dev". PUBLIC_URL pins it; otherwise it comes from the
+// request, using forwarded headers only when a trusted proxy sent them.
+func (app *application) domain(r *http.Request) string {
+	if app.config.publicURL != "" {
+		return app.config.publicURL
+	}
+
+	proto, host := app.forwardedOrigin(r)
+	if host == "" {
+		host = r.Host
+	}
+	if proto == "" {
+		if r.TLS != nil || app.config.appEnv == "production" {
+			proto = "https"
+		} else {
+			proto = "http"
+		}
+	}
+
+	return proto + "://" + host
+}
+
+// requestHost returns the host the client asked for, as reported by a trusted
+// proxy or else by the Host header.
+func (app *application) requestHost(r *http.Request) string {
+	if _, host := app.forwardedOrigin(r); host != "" {
+		return host
+	}
+	return r.Host
+}
+
+// forwardedOrigin returns the protocol and host a trusted proxy reports for r.
+// The RFC 7239 Forwarded header is preferred over X-Forwarded-Proto and
+// X-Forwarded-Host. Only the last entry is used, since that is the one the
+// proxy that connected to us added. Invalid values are ignored.
+func (app *application) forwardedOrigin(r *http.Request) (proto, host string) {
+	if !app.fromTrustedProxy(r) {
+		return "", ""
+	}
+
+	if elements := parseForwarded(r.Header.Values("Forwarded")); len(elements) > 0 {
+		last := elements[len(elements)-1]
+		proto, host = last["proto"], last["host"]
+	} else {
+		proto = lastListValue(r.Header.Values("X-Forwarded-Proto"))
+		host = lastListValue(r.Header.Values("X-Forwarded-Host"))
+	}
+
+	proto = strings.ToLower(proto)
+	if proto != "http" && proto != "https" {
+		proto = ""
+	}
+	if !hostPattern.MatchString(host) {
+		host = ""
+	}
+	return proto, host
+}
+
+// parseForwarded parses RFC 7239 Forwarded header values into one map per
+// element, with lower-case parameter names and unquoted values.
+func parseForwarded(values []string) []map[string]string {
+	var elements []map[string]string
+	for _, value := range values {
+		element := map[string]string{}
+		for len(value) > 0 {
+			var pair string
+			pair, value = cutUnquoted(value, ",;")
+			name, val, ok := strings.Cut(strings.TrimSpace(pair), "=")
+			if ok {
+				element[strings.ToLower(strings.TrimSpace(name))] = unquote(strings.TrimSpace(val))
+			}
+			if strings.HasPrefix(value, ",") && len(element) > 0 {
+				elements = append(elements, element)
+				element = map[string]string{}
+			}
+			if len(value) > 0 {
+				value = value[1:]
+			}
+		}
+		if len(element) > 0 {
+			elements = append(elements, element)
+		}
+	}
+	return elements
+}
+
+// cutUnquoted splits s before the first separator that is not inside a
+// quoted string and returns the remainder starting at that separator.
+func cutUnquoted(s, separators string) (string, string) {
+	quoted := false
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '\\' && quoted:
+			i++
+		case c == '"':
+			quoted = !quoted
+		case !quoted && strings.IndexByte(separators, c) >= 0:
+			return s[:i], s[i:]
+		}
+	}
+	return s, ""
+}
+
+func unquote(value string) string {
+	if len(value) < 2 || value[0] != '"' || value[len(value)-1] != '"' {
+		return value
+	}
+	var b strings.Builder
+	for i := 1; i < len(value)-1; i++ {
+		if value[i] == '\\' && i+1 < len(value)-1 {
+			i++
+		}
+		b.WriteByte(value[i])
+	}
+	return b.String()
+}
+
+func lastListValue(values []string) string {
+	if len(values) == 0 {
+		return ""
+	}
+	items := strings.Split(values[len(values)-1], ",")
+	return strings.TrimSpace(items[len(items)-1])
+}
+
+// parsePublicURL validates PUBLIC_URL and returns it as an origin without a
+// trailing slash. An empty value leaves the origin to each request.
+func parsePublicURL(value string) (string, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return "", nil
+	}
+
+	u, err := url.Parse(value)
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", errors.New("public URL must start with http:// or https://")
+	}
+	if u.Host == "" || !hostPattern.MatchString(u.Host) {
+		return "", fmt.Errorf("public URL has an invalid host %q", u.Host)
+	}
+	if u.User != nil || (u.Path != "" && u.Path != "/") || u.RawQuery != "" || u.Fragment != "" {
+		return "", errors.New("public URL must be an origin such as https://commit.example.com")
+	}
+	return u.Scheme + "://" + u.Host, nil
+}
+
+// knownHosts returns the lower-case host names the server answers for, from
+// ALLOWED_HOSTS plus the PUBLIC_URL host. An empty list allows any host.
+func (cfg config) knownHosts() []string {
+	if len(cfg.allowedHosts) == 0 {
+		return nil
+	}
+
+	hosts := make([]string, 0, len(cfg.allowedHosts)+1)
+	for _, host := range cfg.allowedHosts {
+		hosts = append(hosts, hostname(host))
+	}
+	if cfg.publicURL != "" {
+		if u, err := url.Parse(cfg.publicURL); err == nil {
+			hosts = append(hosts, hostname(u.Host))
+		}
+	}
+	return hosts
+}
+
+func hostname(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.ToLower(strings.TrimSuffix(strings.Trim(host, "[]"), "."))
+}
+
+// allowedHostsMiddleware answers 421 Misdirected Request for hosts that are
+// not in ALLOWED_HOSTS, so a spoofed Host header cannot reach the handlers.
+// Health probes stay reachable for load balancers that probe by IP address.
+func (app *application) allowedHostsMiddleware(next http.Handler) http.Handler {
+	hosts := app.config.knownHosts()
+	if len(hosts) == 0 {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !slices.Contains(probePaths, r.URL.Path) && !slices.Contains(hosts, hostname(app.requestHost(r))) {
+			app.misdirectedRequest(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+)
+
+func TestDomain(t *testing.T) {
+	proxy := []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+
+	tests := []struct {
+		name    string
+		cfg     config
+		remote  string
+		headers map[string]string
+		tls     bool
+		want    string
+	}{
+		{"development", config{appEnv: "development"}, "192.0.2.1:1234", nil, false, "http://commit.jaw.dev"},
+		{"production", config{appEnv: "production"}, "192.0.2.1:1234", nil, false, "https://commit.jaw.dev"},
+		{"tls", config{appEnv: "development"}, "192.0.2.1:1234", nil, true, "https://commit.jaw.dev"},
+		{
+			"untrusted forwarded headers",
+			config{appEnv: "development"},
+			"192.0.2.1:1234",
+			map[string]string{"X-Forwarded-Proto": "https", "X-Forwarded-Host": "evil.example", "Forwarded": "host=evil.example"},
+			false,
+			"http://commit.jaw.dev",
+		},
+		{
+			"trusted x-forwarded",
+			config{appEnv: "development", trustedProxies: proxy},
+			"10.0.0.2:1234",
+			map[string]string{"X-Forwarded-Proto": "https", "X-Forwarded-Host": "spoofed.example, commit.example.com"},
+			false,
+			"https://commit.example.com",
+		},
+		{
+			"trusted forwarded",
+			config{appEnv: "production", trustedProxies: proxy},
+			"10.0.0.2:1234",
+			map[string]string{"Forwarded": `for=192.0.2.1;host=spoofed.example, for="[2001:db8::1]:80";proto=http;host="commit.example.com:8080"`},
+			false,
+			"http://commit.example.com:8080",
+		},
+		{
+			"forwarded wins over x-forwarded",
+			config{appEnv: "development", trustedProxies: proxy},
+			"10.0.0.2:1234",
+			map[string]string{"Forwarded": "proto=https;host=commit.example.com", "X-Forwarded-Host": "other.example"},
+			false,
+			"https://commit.example.com",
+		},
+		{
+			"invalid forwarded values",
+			config{appEnv: "development", trustedProxies: proxy},
+			"10.0.0.2:1234",
+			map[string]string{"X-Forwarded-Proto": "javascript", "X-Forwarded-Host": "evil.example/path"},
+			false,
+			"http://commit.jaw.dev",
+		},
+		{
+			"public url",
+			config{appEnv: "development", trustedProxies: proxy, publicURL: "https://commit.example.com"},
+			"10.0.0.2:1234",
+			map[string]string{"X-Forwarded-Host": "other.example"},
+			false,
+			"https://commit.example.com",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApp()
+			app.config = tt.cfg
+			req := httptest.NewRequest(http.MethodGet, "http://commit.jaw.dev/", nil)
+			req.RemoteAddr = tt.remote
+			for name, value := range tt.headers {
+				req.Header.Set(name, value)
+			}
+			if tt.tls {
+				req.TLS = &tls.ConnectionState{}
+			}
+
+			if got := app.domain(req); got != tt.want {
+				t.Errorf("domain() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseForwarded(t *testing.T) {
+	elements := parseForwarded([]string{
+		`for=192.0.2.60;proto=http;by=203.0.113.43`,
+		`For="[2001:db8:cafe::17]:4711", host="a\"b;c,d"`,
+	})
+
+	if len(elements) != 3 {
+		t.Fatalf("got %d elements, want 3: %v", len(elements), elements)
+	}
+	if got := elements[0]["proto"]; got != "http" {
+		t.Errorf("proto = %q, want http", got)
+	}
+	if got := elements[1]["for"]; got != "[2001:db8:cafe::17]:4711" {
+		t.Errorf("for = %q, want [2001:db8:cafe::17]:4711", got)
+	}
+	if got := elements[2]["host"]; got != `a"b;c,d` {
+		t.Errorf("host = %q, want a\"b;c,d", got)
+	}
+}
+
+func TestParsePublicURL(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    string
+		wantErr bool
+	}{
+		{"", "", false},
+		{"https://commit.example.com", "https://commit.example.com", false},
+		{"https://commit.example.com/", "https://commit.example.com", false},
+		{"http://localhost:8080", "http://localhost:8080", false},
+		{"commit.example.com", "", true},
+		{"ftp://commit.example.com", "", true},
+		{"https://commit.example.com/path", "", true},
+		{"https://user@commit.example.com", "", true},
+		{"https://commit.example.com?x=1", "", true},
+	}
+
+	for _, tt := range tests {
+		got, err := parsePublicURL(tt.value)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parsePublicURL(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parsePublicURL(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestAllowedHostsMiddleware(t *testing.T) {
+	app := newTestApp()
+	app.config.allowedHosts = []string{"commit.example.com"}
+	app.config.publicURL = "https://commit.jaw.dev"
+	app.config.trustedProxies = []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+	handler := app.routes()
+
+	request := func(target string, headers map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		req.Header.Set("User-Agent", "curl/8.0.0")
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	for _, target := range []string{"http://commit.example.com/", "http://COMMIT.example.com:8080/", "http://commit.jaw.dev/commit.sh"} {
+		if rr := request(target, nil); rr.Code != http.StatusOK {
+			t.Errorf("%s: status = %d, want %d", target, rr.Code, http.StatusOK)
+		}
+	}
+
+	rr := request("http://evil.example/commit.sh", nil)
+	if rr.Code != http.StatusMisdirectedRequest {
+		t.Errorf("unknown host status = %d, want %d", rr.Code, http.StatusMisdirectedRequest)
+	}
+	if strings.Contains(rr.Body.String(), "#!/") {
+		t.Error("unknown host was served the script")
+	}
+
+	if rr := request("http://10.0.0.5/healthz", nil); rr.Code != http.StatusOK {
+		t.Errorf("/healthz by IP status = %d, want %d", rr.Code, http.StatusOK)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "http://commit.example.com/", nil)
+	req.RemoteAddr = "10.0.0.2:1234"
+	req.Header.Set("X-Forwarded-Host", "evil.example")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusMisdirectedRequest {
+		t.Errorf("forwarded unknown host status = %d, want %d", rr.Code, http.StatusMisdirectedRequest)
+	}
+}
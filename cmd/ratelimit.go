@@ -0,0 +1,100 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	bucketIdleTimeout = 10 * time.Minute
+	bucketSweepPeriod = time.Minute
+)
+
+type rateLimit struct {
+	perMinute int
+	burst     int
+}
+
+// rateLimiter is an in-memory token bucket per client key. Idle buckets are
+// swept from allow, at most once per bucketSweepPeriod.
+type rateLimiter struct {
+	rate      float64
+	burst     float64
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(limit rateLimit) *rateLimiter {
+	return &rateLimiter{
+		rate:    float64(limit.perMinute) / 60,
+		burst:   float64(max(limit.burst, 1)),
+		buckets: make(map[string]*bucket),
+	}
+}
+
+// allow takes a token for key and, when none is left, reports how long the
+// client has to wait for the next one.
+func (l *rateLimiter) allow(key string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.lastSweep) >= bucketSweepPeriod {
+		l.sweep(now)
+	}
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+
+	b.tokens = min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+
+	wait := (1 - b.tokens) / l.rate
+	return false, time.Duration(wait * float64(time.Second))
+}
+
+func (l *rateLimiter) sweep(now time.Time) {
+	for key, b := range l.buckets {
+		if now.Sub(b.last) >= bucketIdleTimeout {
+			delete(l.buckets, key)
+		}
+	}
+	l.lastSweep = now
+}
+
+// rateLimitMiddleware returns a middleware that limits requests per client IP
+// using the named limit from config.rateLimits. Every handler it wraps shares
+// the same buckets. A missing or zero limit disables limiting.
+func (app *application) rateLimitMiddleware(name string) func(http.Handler) http.Handler {
+	limit := app.config.rateLimits[name]
+	if limit.perMinute <= 0 {
+		return func(next http.Handler) http.Handler { return next }
+	}
+	limiter := newRateLimiter(limit)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ok, wait := limiter.allow(app.clientIP(r), time.Now())
+			if !ok {
+				app.tooManyRequests(w, r, int(math.Ceil(wait.Seconds())))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	limiter := newRateLimiter(rateLimit{perMinute: 60, burst: 2})
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	for i := range 2 {
+		if ok, _ := limiter.allow("client", now); !ok {
+			t.Fatalf("request %d was limited within the burst", i+1)
+		}
+	}
+	ok, wait := limiter.allow("client", now)
+	if ok {
+		t.Fatal("request beyond the burst was allowed")
+	}
+	if wait != time.Second {
+		t.Errorf("wait = %v, want 1s", wait)
+	}
+	if ok, _ := limiter.allow("other", now); !ok {
+		t.Error("another client shared the exhausted bucket")
+	}
+	if ok, _ := limiter.allow("client", now.Add(time.Second)); !ok {
+		t.Error("bucket did not refill after one second")
+	}
+}
+
+func TestRateLimiterEvictsIdleBuckets(t *testing.T) {
+	limiter := newRateLimiter(rateLimit{perMinute: 60, burst: 1})
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	limiter.allow("idle", now)
+	limiter.allow("active", now.Add(bucketIdleTimeout-time.Second))
+	if _, ok := limiter.buckets["idle"]; !ok {
+		t.Fatal("bucket was evicted before it was idle")
+	}
+
+	limiter.allow("active", now.Add(bucketIdleTimeout+bucketSweepPeriod))
+
+	if _, ok := limiter.buckets["idle"]; ok {
+		t.Error("idle bucket was not evicted")
+	}
+	if _, ok := limiter.buckets["active"]; !ok {
+		t.Error("active bucket was evicted")
+	}
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	app := newTestApp()
+	app.config.rateLimits = map[string]rateLimit{"script": {perMinute: 1, burst: 2}}
+	handler := app.routes()
+
+	request := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "http://commit.jaw.dev"+path, nil)
+		req.Header.Set("User-Agent", "curl/8.0.0")
+		req.RemoteAddr = "192.0.2.10:1234"
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	if rr := request("/"); rr.Code != http.StatusOK {
+		t.Fatalf("first request status = %d", rr.Code)
+	}
+	if rr := request("/commit.sh.sha256"); rr.Code != http.StatusOK {
+		t.Fatalf("second request status = %d", rr.Code)
+	}
+
+	rr := request("/install.sh")
+	if rr.Code != http.StatusTooManyRequests {
+		t.Fatalf("status = %d, want %d", rr.Code, http.StatusTooManyRequests)
+	}
+	if got := rr.Header().Get("Retry-After"); got != "60" {
+		t.Errorf("Retry-After = %q, want 60", got)
+	}
+	if got := rr.Header().Get("Content-Type"); got != "application/json" {
+		t.Errorf("Content-Type = %q, want the respond JSON body", got)
+	}
+
+	if rr := request("/healthz"); rr.Code != http.StatusOK {
+		t.Errorf("unlimited route status = %d, want %d", rr.Code, http.StatusOK)
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	trusted, err := parseTrustedProxies("10.0.0.0/8, 127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name          string
+		remoteAddr    string
+		forwardedFor  []string
+		wantClientIP  string
+		trustedRanges []netip.Prefix
+	}{
+		{"direct client", "192.0.2.10:1234", nil, "192.0.2.10", trusted},
+		{"untrusted peer cannot spoof", "192.0.2.10:1234", []string{"198.51.100.1"}, "192.0.2.10", trusted},
+		{"trusted proxy", "10.0.0.5:1234", []string{"198.51.100.1"}, "198.51.100.1", trusted},
+		{"spoofed leftmost hop", "10.0.0.5:1234", []string{"203.0.113.9, 198.51.100.1"}, "198.51.100.1", trusted},
+		{"chain of trusted proxies", "127.0.0.1:1234", []string{"198.51.100.1, 10.1.1.1", "10.2.2.2"}, "198.51.100.1", trusted},
+		{"malformed hop", "10.0.0.5:1234", []string{"198.51.100.1, nonsense"}, "10.0.0.5", trusted},
+		{"no trusted proxies", "10.0.0.5:1234", []string{"198.51.100.1"}, "10.0.0.5", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApp()
+			app.config.trustedProxies = tt.trustedRanges
+			req := httptest.NewRequest(http.MethodGet, "http://commit.jaw.dev/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			for _, value := range tt.forwardedFor {
+				req.Header.Add("X-Forwarded-For", value)
+			}
+
+			if got := app.clientIP(req); got != tt.wantClientIP {
+				t.Errorf("clientIP = %q, want %q", got, tt.wantClientIP)
+			}
+		})
+	}
+}
+
+func TestParseTrustedProxies(t *testing.T) {
+	prefixes, err := parseTrustedProxies(" 10.1.2.3/8 ,::1,, 192.0.2.1 ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"10.0.0.0/8", "::1/128", "192.0.2.1/32"}
+	if len(prefixes) != len(want) {
+		t.Fatalf("prefixes = %v, want %v", prefixes, want)
+	}
+	for i, prefix := range prefixes {
+		if prefix.String() != want[i] {
+			t.Errorf("prefix %d = %s, want %s", i, prefix, want[i])
+		}
+	}
+
+	for _, invalid := range []string{"10.0.0.0/33", "proxy.internal"} {
+		if _, err := parseTrustedProxies(invalid); err == nil {
+			t.Errorf("parseTrustedProxies(%q) succeeded", invalid)
+		}
+	}
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	maxRelayDiffStatBytes = 64 * 1024
+	maxRelayFeedbackLen   = 1000
+	maxRelayBodyBytes     = maxPresetDiffBytes + 2*maxRelayDiffStatBytes
+	maxUpstreamBodyBytes  = 1 << 20
+)
+
+// generateRequest is the body of POST /api/v1/generate. RejectedMessage and
+// Suggestion carry the feedback from the script's "suggest" option.
+type generateRequest struct {
+	Diff            string `json:"diff"`
+	DiffStat        string `json:"diff_stat"`
+	Model           string `json:"model"`
+	Language        string `json:"language"`
+	RejectedMessage string `json:"rejected_message"`
+	Suggestion      string `json:"suggestion"`
+}
+
+type generateResponse struct {
+	Message string `json:"message"`
+	Model   string `json:"model"`
+}
+
+// chatRequest and chatResponse are the parts of the OpenAI chat completions
+// API the relay uses.
+type chatRequest struct {
+	Model       string        `json:"model"`
+	Messages    []chatMessage `json:"messages"`
+	Temperature float64       `json:"temperature"`
+	MaxTokens   int           `json:"max_tokens"`
+}
+
+type chatMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type chatResponse struct {
+	Model   string `json:"model"`
+	Choices []struct {
+		Message chatMessage `json:"message"`
+	} `json:"choices"`
+	Usage chatUsage `json:"usage"`
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+type chatUsage struct {
+	PromptTokens     int64 `json:"prompt_tokens"`
+	CompletionTokens int64 `json:"completion_tokens"`
+	TotalTokens      int64 `json:"total_tokens"`
+}
+
+// upstreamError is a non-200 response from the model provider.
+type upstreamError struct {
+	status     int
+	message    string
+	retryAfter string
+}
+
+func (e *upstreamError) Error() string {
+	if e.message == "" {
+		return fmt.Sprintf("the model provider returned HTTP %d", e.status)
+	}
+	return fmt.Sprintf("the model provider returned HTTP %d: %s", e.status, e.message)
+}
+
+// relayModels returns the models clients may ask the relay for. The first is
+// used when a request names none.
+func (cfg config) relayModels() []string {
+	if len(cfg.relayAllowedModels) > 0 {
+		return cfg.relayAllowedModels
+	}
+	if cfg.brand.DefaultModel != "" {
+		return []string{cfg.brand.DefaultModel}
+	}
+	return []string{defaultBranding.DefaultModel}
+}
+
+// validate checks req and returns the model to use.
+func (req generateRequest) validate(allowed []string) (string, error) {
+	switch {
+	case strings.TrimSpace(req.Diff) == "":
+		return "", errors.New("diff must not be empty")
+	case len(req.Diff) > maxPresetDiffBytes:
+		return "", fmt.Errorf("diff must not exceed %d bytes", maxPresetDiffBytes)
+	case len(req.DiffStat) > maxRelayDiffStatBytes:
+		return "", fmt.Errorf("diff_stat must not exceed %d bytes", maxRelayDiffStatBytes)
+	case req.Language != "" && !languageTagPattern.MatchString(req.Language):
+		return "", errors.New("language must be a language tag such as en or pt-BR")
+	case len(req.RejectedMessage) > maxRelayFeedbackLen || len(req.Suggestion) > maxRelayFeedbackLen:
+		return "", fmt.Errorf("rejected_message and suggestion must not exceed %d bytes", maxRelayFeedbackLen)
+	}
+
+	if req.Model == "" {
+		return allowed[0], nil
+	}
+	if !slices.Contains(allowed, req.Model) {
+		return "", fmt.Errorf("model %q is not available, use one of: %s", req.Model, strings.Join(allowed, ", "))
+	}
+	return req.Model, nil
+}
+
+// chatRequest builds the same chat completion request commit.sh sends to the
+// provider directly.
+func (req generateRequest) chatRequest(prompt, model string) chatRequest {
+	system := prompt
+	if req.Language != "" {
+		system += fmt.Sprintf("\n\nWrite the description in the language with the BCP 47 tag \"%s\". Keep the type and scope in English.", req.Language)
+	}
+	if req.RejectedMessage != "" && req.Suggestion != "" {
+		system += fmt.Sprintf("\n\nThe developer rejected this commit message: \"%s\"\nThe developer wants the commit message to: %s\nGenerate a completely new commit message that incorporates the developer feedback. Still follow all formatting rules above.", req.RejectedMessage, req.Suggestion)
+	}
+
+	user := req.Diff
+	if req.DiffStat != "" {
+		user = "Summary of changed files (git diff --stat --summary):\n" + req.DiffStat + "\n\nFull diff:\n" + req.Diff
+	}
+
+	return chatRequest{
+		Model: model,
+		Messages: []chatMessage{
+			{Role: "system", Content: system},
+			{Role: "user", Content: user},
+		},
+		Temperature: 0.2,
+		MaxTokens:   200,
+	}
+}
+
+// callUpstream sends chat to the configured provider with the server's key.
+func (app *application) callUpstream(ctx context.Context, chat chatRequest) (chatResponse, error) {
+	var result chatResponse
+
+	body, err := json.Marshal(chat)
+	if err != nil {
+		return result, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, app.config.relayTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, app.brand().APIURL(), bytes.NewReader(body))
+	if err != nil {
+		return result, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+app.config.relayAPIKey)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return result, err
+	}
+	defer resp.Body.Close()
+
+	decodeErr := json.NewDecoder(io.LimitReader(resp.Body, maxUpstreamBodyBytes)).Decode(&result)
+	if resp.StatusCode != http.StatusOK {
+		upstream := &upstreamError{status: resp.StatusCode, retryAfter: resp.Header.Get("Retry-After")}
+		if decodeErr == nil && result.Error != nil {
+			upstream.message = result.Error.Message
+		}
+		return result, upstream
+	}
+	if decodeErr != nil {
+		return result, fmt.Errorf("decoding the model provider response: %w", decodeErr)
+	}
+	if len(result.Choices) == 0 || strings.TrimSpace(result.Choices[0].Message.Content) == "" {
+		return result, errors.New("the model provider returned an empty message")
+	}
+	return result, nil
+}
+
+// handleGenerate generates a commit message for a diff with the server's
+// provider key, so developers do not need keys of their own.
+func (app *application) handleGenerate(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRelayBodyBytes)
+
+	var req generateRequest
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			app.requestTooLarge(w, r, tooLarge.Limit)
+			return
+		}
+		app.invalidRequest(w, r, fmt.Errorf("request body must be a JSON object: %v", err))
+		return
+	}
+
+	gen := generationFrom(r)
+	gen.setDiff(req.Diff, req.DiffStat)
+
+	model, err := req.validate(app.config.relayModels())
+	if err != nil {
+		app.invalidRequest(w, r, err)
+		return
+	}
+	gen.model = model
+
+	prompt, err := siteAssets.prompt()
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	// The provider may take longer than WRITE_TIMEOUT allows for other
+	// responses.
+	_ = http.NewResponseController(w).SetWriteDeadline(time.Now().Add(app.config.relayTimeout + 5*time.Second))
+
+	result, err := app.callUpstream(r.Context(), req.chatRequest(prompt, model))
+	gen.usage = result.Usage
+	if err != nil {
+		app.upstreamFailed(w, r, err)
+		return
+	}
+
+	response := generateResponse{
+		Message: strings.TrimSpace(result.Choices[0].Message.Content),
+		Model:   cmp.Or(result.Model, model),
+	}
+	gen.model = response.Model
+	gen.messageBytes = len(response.Message)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		app.reportServerError(r, err)
+	}
+}
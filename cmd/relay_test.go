@@ -0,0 +1,278 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeUpstream is an OpenAI-compatible provider that records the last request.
+type fakeUpstream struct {
+	*httptest.Server
+	auth  string
+	calls int
+	chat  chatRequest
+}
+
+func newFakeUpstream(t *testing.T, respond func(w http.ResponseWriter)) *fakeUpstream {
+	t.Helper()
+	upstream := &fakeUpstream{}
+	upstream.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/chat/completions" {
+			http.NotFound(w, r)
+			return
+		}
+		upstream.calls++
+		upstream.auth = r.Header.Get("Authorization")
+		if err := json.NewDecoder(r.Body).Decode(&upstream.chat); err != nil {
+			t.Errorf("decoding upstream request: %v", err)
+		}
+		respond(w)
+	}))
+	t.Cleanup(upstream.Close)
+	return upstream
+}
+
+func replyWith(status int, body string) func(http.ResponseWriter) {
+	return func(w http.ResponseWriter) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}
+}
+
+func newRelayApp(upstream *fakeUpstream) *application {
+	app := newTestApp()
+	app.config.brand = defaultBranding
+	app.config.brand.ProviderURL = upstream.URL + "/v1"
+	app.config.relayAPIKey = "server-secret"
+	app.config.relayTimeout = 5 * time.Second
+	return app
+}
+
+func postGenerate(app *application, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "http://commit.example.com/api/v1/generate", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/problem+json, application/json")
+	rr := httptest.NewRecorder()
+	app.routes().ServeHTTP(rr, req)
+	return rr
+}
+
+func TestHandleGenerate(t *testing.T) {
+	upstream := newFakeUpstream(t, replyWith(http.StatusOK, `{"model":"openrouter/some-free-model","choices":[{"message":{"role":"assistant","content":"feat: add relay\n"}}]}`))
+	app := newRelayApp(upstream)
+
+	rr := postGenerate(app, `{"diff":"+relay\n","diff_stat":" relay.go | 1 +","language":"de","rejected_message":"feat: stuff","suggestion":"be specific"}`)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", rr.Code, http.StatusOK, rr.Body)
+	}
+	var response generateResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if response != (generateResponse{Message: "feat: add relay", Model: "openrouter/some-free-model"}) {
+		t.Errorf("response = %+v", response)
+	}
+
+	if upstream.auth != "Bearer server-secret" {
+		t.Errorf("upstream Authorization = %q", upstream.auth)
+	}
+	chat := upstream.chat
+	if chat.Model != "openrouter/free" || chat.MaxTokens != 200 || len(chat.Messages) != 2 {
+		t.Fatalf("upstream request = %+v", chat)
+	}
+	for _, want := range []string{
+		"Generate a concise, professional, single-line commit message",
+		`BCP 47 tag "de"`,
+		`rejected this commit message: "feat: stuff"`,
+		"wants the commit message to: be specific",
+	} {
+		if !strings.Contains(chat.Messages[0].Content, want) {
+			t.Errorf("system prompt does not contain %q", want)
+		}
+	}
+	if want := "Summary of changed files (git diff --stat --summary):\n relay.go | 1 +\n\nFull diff:\n+relay\n"; chat.Messages[1].Content != want {
+		t.Errorf("user message = %q, want %q", chat.Messages[1].Content, want)
+	}
+}
+
+func TestHandleGenerateRejectsInvalidRequests(t *testing.T) {
+	upstream := newFakeUpstream(t, replyWith(http.StatusOK, `{}`))
+
+	tests := []struct {
+		name   string
+		body   string
+		status int
+		want   string
+	}{
+		{"not json", `diff`, http.StatusBadRequest, "must be a JSON object"},
+		{"unknown field", `{"diff":"x","api_key":"k"}`, http.StatusBadRequest, "unknown field"},
+		{"empty diff", `{"diff":"  "}`, http.StatusBadRequest, "diff must not be empty"},
+		{"model not allowed", `{"diff":"x","model":"openai/gpt-4o"}`, http.StatusBadRequest, "is not available"},
+		{"language", `{"diff":"x","language":"not a tag"}`, http.StatusBadRequest, "language must be"},
+		{"too large", `{"diff":"` + strings.Repeat("x", maxRelayBodyBytes) + `"}`, http.StatusRequestEntityTooLarge, "must not exceed"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := postGenerate(newRelayApp(upstream), tt.body)
+
+			if rr.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", rr.Code, tt.status, rr.Body)
+			}
+			if !strings.Contains(rr.Body.String(), tt.want) {
+				t.Errorf("body = %s, want %q", rr.Body, tt.want)
+			}
+		})
+	}
+	if upstream.calls != 0 {
+		t.Errorf("upstream was called %d times", upstream.calls)
+	}
+}
+
+func TestHandleGenerateAllowedModels(t *testing.T) {
+	upstream := newFakeUpstream(t, replyWith(http.StatusOK, `{"choices":[{"message":{"content":"fix: x"}}]}`))
+	app := newRelayApp(upstream)
+	app.config.relayAllowedModels = []string{"team/small", "team/large"}
+
+	if rr := postGenerate(app, `{"diff":"x"}`); rr.Code != http.StatusOK || upstream.chat.Model != "team/small" {
+		t.Errorf("default model: status %d, upstream model %q", rr.Code, upstream.chat.Model)
+	}
+	if rr := postGenerate(app, `{"diff":"x","model":"team/large"}`); rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"model":"team/large"`) {
+		t.Errorf("chosen model: status %d, body %s", rr.Code, rr.Body)
+	}
+	if rr := postGenerate(app, `{"diff":"x","model":"openrouter/free"}`); rr.Code != http.StatusBadRequest {
+		t.Errorf("unlisted model: status %d, want %d", rr.Code, http.StatusBadRequest)
+	}
+}
+
+func TestHandleGenerateUpstreamErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		respond    func(http.ResponseWriter)
+		status     int
+		kind       string
+		hidden     string
+		retryAfter string
+	}{
+		{"provider error", replyWith(http.StatusUnauthorized, `{"error":{"message":"invalid key"}}`), http.StatusBadGateway, "/problems/upstream-error", "invalid key", ""},
+		{"rate limited", func(w http.ResponseWriter) {
+			w.Header().Set("Retry-After", "7")
+			replyWith(http.StatusTooManyRequests, `{"error":{"message":"slow down"}}`)(w)
+		}, http.StatusTooManyRequests, "/problems/upstream-rate-limited", "slow down", "7"},
+		{"empty message", replyWith(http.StatusOK, `{"choices":[]}`), http.StatusBadGateway, "/problems/upstream-error", "empty message", ""},
+		{"not json", replyWith(http.StatusOK, `<html>`), http.StatusBadGateway, "/problems/upstream-error", "decoding", ""},
+		{"timeout", func(w http.ResponseWriter) { time.Sleep(200 * time.Millisecond) }, http.StatusGatewayTimeout, "/problems/upstream-timeout", "deadline", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newRelayApp(newFakeUpstream(t, tt.respond))
+			app.config.relayTimeout = 50 * time.Millisecond
+
+			rr := postGenerate(app, `{"diff":"x"}`)
+
+			if rr.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", rr.Code, tt.status, rr.Body)
+			}
+			if !strings.Contains(rr.Body.String(), tt.kind) {
+				t.Errorf("body = %s, want %q", rr.Body, tt.kind)
+			}
+			if strings.Contains(rr.Body.String(), tt.hidden) {
+				t.Errorf("body = %s, want the provider error %q left out", rr.Body, tt.hidden)
+			}
+			if got := rr.Header().Get("Retry-After"); got != tt.retryAfter {
+				t.Errorf("Retry-After = %q, want %q", got, tt.retryAfter)
+			}
+			if strings.Contains(rr.Body.String(), "server-secret") {
+				t.Error("response contains the provider key")
+			}
+		})
+	}
+}
+
+func TestHandleHomeMentionsRelay(t *testing.T) {
+	app := newRelayApp(newFakeUpstream(t, replyWith(http.StatusOK, `{}`)))
+	req := httptest.NewRequest(http.MethodGet, "http://commit.example.com/", nil)
+	req.Header.Set("User-Agent", "Mozilla/5.0")
+	rr := httptest.NewRecorder()
+
+	app.handleHome(rr, req)
+
+	if !strings.Contains(rr.Body.String(), "--no-relay") {
+		t.Error("home page does not mention --no-relay")
+	}
+}
+
+func TestGenerateRouteIsOptIn(t *testing.T) {
+	upstream := newFakeUpstream(t, replyWith(http.StatusOK, `{}`))
+	app := newRelayApp(upstream)
+	app.config.relayAPIKey = ""
+
+	if rr := postGenerate(app, `{"diff":"x"}`); rr.Code != http.StatusMethodNotAllowed {
+		t.Errorf("status = %d, want %d", rr.Code, http.StatusMethodNotAllowed)
+	}
+	if upstream.calls != 0 {
+		t.Errorf("upstream was called %d times", upstream.calls)
+	}
+}
+
+func TestCommitScriptUsesRelay(t *testing.T) {
+	if _, err := exec.LookPath("curl"); err != nil {
+		t.Skip("curl is not installed")
+	}
+
+	upstream := newFakeUpstream(t, replyWith(http.StatusOK, `{"choices":[{"message":{"content":"feat: relay message"}}]}`))
+	app := newRelayApp(upstream)
+	server := httptest.NewServer(app.routes())
+	defer server.Close()
+
+	var script bytes.Buffer
+	if err := renderScript(&script, "commit.sh", scriptData{Domain: server.URL, Brand: app.brand(), Relay: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	root := t.TempDir()
+	repo := filepath.Join(root, "repo")
+	if err := os.Mkdir(repo, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	runGit(t, repo, "init", "-q")
+	if err := os.WriteFile(filepath.Join(repo, "feature.txt"), []byte("new feature\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	runGit(t, repo, "add", "feature.txt")
+
+	cmd := exec.Command("bash", "-s", "--", "--dry-run")
+	cmd.Dir = repo
+	cmd.Stdin = bytes.NewReader(script.Bytes())
+	cmd.Env = append(os.Environ(),
+		"XDG_CONFIG_HOME="+filepath.Join(root, "config"),
+		"XDG_CACHE_HOME="+filepath.Join(root, "cache"),
+		"OPENROUTER_API_KEY=",
+		"COMMIT_MODEL=",
+		"COMMIT_TTY_INPUT=/dev/null",
+	)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("commit script failed: %v\n%s", err, output)
+	}
+	if !strings.Contains(string(output), "feat: relay message") {
+		t.Errorf("output does not contain the relayed message:\n%s", output)
+	}
+	if upstream.calls != 1 || !strings.Contains(upstream.chat.Messages[1].Content, "+new feature") {
+		t.Errorf("upstream calls = %d, request = %+v", upstream.calls, upstream.chat)
+	}
+	if _, err := os.Stat(filepath.Join(root, "config", "commit", "config.json")); !os.IsNotExist(err) {
+		t.Error("relay mode ran the API key setup")
+	}
+}
@@ -1,19 +1,37 @@
 package main
 
-import (
-	"net/http"
-
-	"github.com/wajeht/commit/assets"
-)
+import "net/http"
 
 func (app *application) routes() http.Handler {
 	mux := http.NewServeMux()
-	mux.Handle("GET /static/", app.stripTrailingSlashMiddleware(http.FileServer(http.FS(assets.Embeddedfiles))))
+	mux.Handle("GET /static/", app.stripTrailingSlashMiddleware(app.staticETagMiddleware(http.FileServer(http.FS(siteAssets.fsys)))))
 	mux.HandleFunc("GET /healthz", app.handleHealthz)
+	mux.HandleFunc("GET /livez", app.handleLivez)
+	mux.HandleFunc("GET /readyz", app.handleReadyz)
+	mux.HandleFunc("GET /version", app.handleVersion)
 	mux.HandleFunc("GET /robots.txt", app.handleRobotsTxt)
 	mux.HandleFunc("GET /favicon.ico", app.handleFavicon)
-	mux.HandleFunc("GET /install.sh", app.handleInstallSh)
-	mux.HandleFunc("GET /", app.handleHome)
+	mux.HandleFunc("GET /pubkey", app.handlePublicKey)
+	mux.HandleFunc("GET /docs/{page}", app.handleDoc)
+	mux.HandleFunc("GET /problems/{kind}", app.handleProblemType)
+
+	scriptLimit := app.rateLimitMiddleware("script")
+	mux.Handle("GET /install.sh", scriptLimit(http.HandlerFunc(app.handleInstallSh)))
+	mux.Handle("GET /install.sh.sha256", scriptLimit(http.HandlerFunc(app.handleScriptChecksum)))
+	mux.Handle("GET /install.sh.sig", scriptLimit(http.HandlerFunc(app.handleScriptSignature)))
+	mux.Handle("GET /commit.sh", scriptLimit(http.HandlerFunc(app.handleCommitSh)))
+	mux.Handle("GET /commit.sh.sha256", scriptLimit(http.HandlerFunc(app.handleScriptChecksum)))
+	mux.Handle("GET /commit.sh.sig", scriptLimit(http.HandlerFunc(app.handleScriptSignature)))
+	mux.Handle("GET /v/{version}/commit.sh", scriptLimit(http.HandlerFunc(app.handleVersionedScript)))
+	mux.Handle("GET /", scriptLimit(http.HandlerFunc(app.handleHome)))
+
+	if app.config.relayAPIKey != "" {
+		mux.Handle("POST /api/v1/generate", app.rateLimitMiddleware("relay")(app.gatewayMiddleware(http.HandlerFunc(app.handleGenerate))))
+	}
+
+	if app.metrics != nil && app.config.metricsAddr == "" && app.config.metricsToken != "" {
+		mux.HandleFunc("GET /metrics", app.handleMetrics)
+	}
 
-	return mux
+	return app.requestIDMiddleware(app.securityHeadersMiddleware(app.logRequestMiddleware(app.metricsMiddleware(app.recoverPanicMiddleware(app.allowedHostsMiddleware(app.muxErrorMiddleware(mux)))))))
 }
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	// defaultMaxDiffBytes matches MAX_DIFF_BYTES in commit.sh.
+	defaultMaxDiffBytes = 1024 * 1024
+	minPresetDiffBytes  = 1024
+	maxPresetDiffBytes  = 8 * 1024 * 1024
+	maxPresetModelLen   = 200
+)
+
+var (
+	modelIDPattern     = regexp.MustCompile(`^[A-Za-z0-9._:/@+~-]+$`)
+	languageTagPattern = regexp.MustCompile(`^[A-Za-z]{2,3}(-[A-Za-z0-9]{2,8})*$`)
+)
+
+// scriptData is rendered into commit.sh. Zero values keep the defaults
+// written in the script itself.
+type scriptData struct {
+	Domain   string
+	Version  string
+	Revision string
+	Brand    branding
+	Relay    bool
+	Defaults scriptDefaults
+}
+
+type scriptDefaults struct {
+	Model        string
+	AutoAccept   bool
+	Language     string
+	MaxDiffBytes int
+}
+
+// parseScriptDefaults validates the preset query parameters accepted by the
+// script endpoint, e.g. "?model=openrouter/auto&yes=1&lang=de".
+func parseScriptDefaults(query url.Values) (scriptDefaults, error) {
+	var defaults scriptDefaults
+
+	for _, key := range slices.Sorted(maps.Keys(query)) {
+		values := query[key]
+		if len(values) != 1 {
+			return defaults, fmt.Errorf("query parameter %q must be set once", key)
+		}
+		value := values[0]
+
+		switch key {
+		case "model":
+			if len(value) > maxPresetModelLen || !modelIDPattern.MatchString(value) {
+				return defaults, errors.New("model must be a model ID without whitespace")
+			}
+			defaults.Model = value
+		case "yes":
+			autoAccept, err := strconv.ParseBool(value)
+			if err != nil {
+				return defaults, errors.New("yes must be a boolean")
+			}
+			defaults.AutoAccept = autoAccept
+		case "lang":
+			if !languageTagPattern.MatchString(value) {
+				return defaults, errors.New("lang must be a language tag such as en or pt-BR")
+			}
+			defaults.Language = value
+		case "raw":
+			// raw=1 asks for the script itself; see negotiate.
+			if _, err := strconv.ParseBool(value); err != nil {
+				return defaults, errors.New("raw must be a boolean")
+			}
+		case "max_diff_bytes":
+			size, err := strconv.Atoi(value)
+			if err != nil || size < minPresetDiffBytes || size > maxPresetDiffBytes {
+				return defaults, fmt.Errorf("max_diff_bytes must be between %d and %d", minPresetDiffBytes, maxPresetDiffBytes)
+			}
+			defaults.MaxDiffBytes = size
+		default:
+			return defaults, fmt.Errorf("unknown query parameter %q", key)
+		}
+	}
+
+	return defaults, nil
+}
+
+// shellScriptURL returns the script URL as it should be typed in a shell, keeping
+// any presets from the query string.
+func shellScriptURL(domain string, query url.Values) string {
+	query = maps.Clone(query)
+	delete(query, "raw")
+	if len(query) == 0 {
+		return domain
+	}
+	return shellQuote(domain + "/?" + query.Encode())
+}
+
+// formatSize formats a byte count the way format_size in commit.sh does.
+func formatSize(bytes int) string {
+	switch {
+	case bytes%(1024*1024) == 0:
+		return fmt.Sprintf("%d MiB", bytes/(1024*1024))
+	case bytes%1024 == 0:
+		return fmt.Sprintf("%d KiB", bytes/1024)
+	default:
+		return fmt.Sprintf("%d bytes", bytes)
+	}
+}
+
+// renderScript renders one of the served scripts, "commit.sh" or "install.sh".
+func renderScript(w io.Writer, name string, data scriptData) error {
+	tmpl, err := siteAssets.script(name)
+	if err != nil {
+		return err
+	}
+	return tmpl.Execute(w, data)
+}
+
+func shellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
+
+func shellAssign(name string, value any) (string, error) {
+	switch v := value.(type) {
+	case string:
+		if v == "" {
+			return "", nil
+		}
+		return name + "=" + shellQuote(v), nil
+	case int:
+		if v == 0 {
+			return "", nil
+		}
+		return name + "=" + strconv.Itoa(v), nil
+	case bool:
+		if !v {
+			return "", nil
+		}
+		return name + "=true", nil
+	default:
+		return "", fmt.Errorf("cannot assign %T to %s", value, name)
+	}
+}
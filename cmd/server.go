@@ -2,48 +2,175 @@ package main
 
 import (
 	"context"
+	"crypto/ed25519"
 	"errors"
 	"fmt"
+	"io/fs"
 	"log/slog"
+	"net"
 	"net/http"
+	"net/netip"
 	"os"
 	"os/signal"
+	"sync/atomic"
 	"syscall"
 	"time"
 )
 
 type config struct {
-	appPort int
-	appEnv  string
+	appPort        int
+	listen         string
+	socketMode     fs.FileMode
+	socketGroup    string
+	appEnv         string
+	signingKey     ed25519.PrivateKey
+	metricsAddr    string
+	metricsToken   string
+	trustedProxies []netip.Prefix
+	rateLimits     map[string]rateLimit
+	publicURL      string
+	allowedHosts   []string
+	assetsDir      string
+	brand          branding
+
+	relayAPIKey        string
+	relayAllowedModels []string
+	relayTimeout       time.Duration
+	authDB             string
+	quota              quota
+	auditLog           string
+	auditLogDiffs      bool
+
+	tlsCertFile      string
+	tlsKeyFile       string
+	httpRedirectPort int
+	acmeDomains      []string
+	acmeEmail        string
+	acmeCacheDir     string
+	acmeDirectoryURL string
+	acmeCARoot       string
+
+	readHeaderTimeout time.Duration
+	readTimeout       time.Duration
+	writeTimeout      time.Duration
+	idleTimeout       time.Duration
+	maxHeaderBytes    int
+	drainDelay        time.Duration
+	shutdownTimeout   time.Duration
 }
 
 type application struct {
-	config config
-	logger *slog.Logger
+	config  config
+	logger  *slog.Logger
+	metrics *metrics
+	tokens  *tokenStore
+	audit   *auditLog
+
+	// tlsPort is the port the main listener actually bound, which
+	// redirectToHTTPS sends clients to.
+	tlsPort int
+
+	// draining is set once shutdown starts, so /healthz fails while load
+	// balancers take the instance out of rotation.
+	draining atomic.Bool
 }
 
 func (app *application) serve() error {
-	server := &http.Server{
-		Addr:    fmt.Sprintf(":%d", app.config.appPort),
-		Handler: app.routes(),
+	listener, err := app.listen()
+	if err != nil {
+		return err
+	}
+	return app.serveListener(listener)
+}
+
+// newServer returns an http.Server for handler with the configured limits.
+func (app *application) newServer(addr string, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: app.config.readHeaderTimeout,
+		ReadTimeout:       app.config.readTimeout,
+		WriteTimeout:      app.config.writeTimeout,
+		IdleTimeout:       app.config.idleTimeout,
+		MaxHeaderBytes:    app.config.maxHeaderBytes,
+		ErrorLog:          slog.NewLogLogger(app.logger.Handler(), slog.LevelWarn),
+	}
+}
+
+// serveListener serves the application on listener until SIGINT or SIGTERM.
+// On a signal, /healthz reports 503 for the drain delay so load balancers stop
+// routing new requests, then in-flight requests get up to the shutdown
+// timeout to finish.
+func (app *application) serveListener(listener net.Listener) error {
+	tlsConfig, httpHandler, err := app.tlsSetup()
+	if err != nil {
+		listener.Close()
+		return err
+	}
+
+	if addr, ok := listener.Addr().(*net.TCPAddr); ok {
+		app.tlsPort = addr.Port
+	}
+	if tlsConfig != nil && app.config.httpRedirectPort != 0 && app.config.httpRedirectPort == app.tlsPort {
+		listener.Close()
+		return fmt.Errorf("HTTP_REDIRECT_PORT %d is the port the main listener uses", app.tlsPort)
+	}
+
+	server := app.newServer(listener.Addr().String(), app.routes())
+	server.TLSConfig = tlsConfig
+
+	// Auxiliary servers run in the background and are shut down with the
+	// main server.
+	var auxiliary []*http.Server
+	if app.config.metricsAddr != "" {
+		auxiliary = append(auxiliary, app.newServer(app.config.metricsAddr, app.metricsRoutes()))
+	}
+	if tlsConfig != nil && app.config.httpRedirectPort != 0 {
+		auxiliary = append(auxiliary, app.newServer(fmt.Sprintf(":%d", app.config.httpRedirectPort), httpHandler))
+	}
+
+	for _, aux := range auxiliary {
+		go func() {
+			app.logger.Info("auxiliary server starting", "addr", aux.Addr)
+			err := aux.ListenAndServe()
+			if !errors.Is(err, http.ErrServerClosed) {
+				app.logger.Error("auxiliary server failed", "addr", aux.Addr, "error", err)
+			}
+		}()
 	}
 
 	shutdownErrorChan := make(chan error)
 
+	quitChan := make(chan os.Signal, 1)
+	signal.Notify(quitChan, syscall.SIGINT, syscall.SIGTERM)
+
 	go func() {
-		quitChan := make(chan os.Signal, 1)
-		signal.Notify(quitChan, syscall.SIGINT, syscall.SIGTERM)
-		<-quitChan
+		defer signal.Stop(quitChan)
+		sig := <-quitChan
 
-		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		app.logger.Info("server draining", "signal", sig.String(), "delay", app.config.drainDelay.String())
+		app.draining.Store(true)
+		time.Sleep(app.config.drainDelay)
+
+		ctx, cancel := context.WithTimeout(context.Background(), app.config.shutdownTimeout)
 		defer cancel()
 
+		for _, aux := range auxiliary {
+			if err := aux.Shutdown(ctx); err != nil {
+				app.logger.Error("auxiliary server shutdown failed", "addr", aux.Addr, "error", err)
+			}
+		}
+
 		shutdownErrorChan <- server.Shutdown(ctx)
 	}()
 
-	app.logger.Info("server starting", "addr", server.Addr)
+	app.logger.Info("server starting", "addr", server.Addr, "tls", tlsConfig != nil)
 
-	err := server.ListenAndServe()
+	if tlsConfig != nil {
+		err = server.ServeTLS(listener, "", "")
+	} else {
+		err = server.Serve(listener)
+	}
 	if !errors.Is(err, http.ErrServerClosed) {
 		return err
 	}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestServeListenerGracefulShutdown(t *testing.T) {
+	app := newTestApp()
+	app.config.appEnv = "development"
+	app.config.readHeaderTimeout = time.Second
+	app.config.drainDelay = 300 * time.Millisecond
+	app.config.shutdownTimeout = 5 * time.Second
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	url := "http://" + listener.Addr().String()
+
+	done := make(chan error, 1)
+	go func() { done <- app.serveListener(listener) }()
+
+	healthz := func() int {
+		client := &http.Client{Timeout: time.Second, Transport: &http.Transport{DisableKeepAlives: true}}
+		resp, err := client.Get(url + "/healthz")
+		if err != nil {
+			return 0
+		}
+		defer resp.Body.Close()
+		io.Copy(io.Discard, resp.Body)
+		return resp.StatusCode
+	}
+
+	if status := healthz(); status != http.StatusOK {
+		t.Fatalf("/healthz before shutdown = %d, want %d", status, http.StatusOK)
+	}
+
+	// A slow request that is in flight when shutdown starts must complete.
+	slow, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer slow.Close()
+
+	if err := syscall.Kill(syscall.Getpid(), syscall.SIGTERM); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(app.config.drainDelay)
+	status := healthz()
+	for status == http.StatusOK && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		status = healthz()
+	}
+	if status != http.StatusServiceUnavailable {
+		t.Errorf("/healthz while draining = %d, want %d", status, http.StatusServiceUnavailable)
+	}
+
+	if _, err := io.WriteString(slow, "GET /healthz HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n"); err != nil {
+		t.Fatal(err)
+	}
+	if body, err := io.ReadAll(slow); err != nil || len(body) == 0 {
+		t.Errorf("in-flight connection got %q, %v", body, err)
+	}
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("serveListener() = %v, want nil", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("server did not shut down")
+	}
+
+	if _, err := net.DialTimeout("tcp", listener.Addr().String(), time.Second); err == nil {
+		t.Error("listener still accepts connections after shutdown")
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func TestParseSigningKey(t *testing.T) {
+	seed := bytes.Repeat([]byte{7}, ed25519.SeedSize)
+
+	tests := []struct {
+		name    string
+		value   string
+		wantKey bool
+		wantErr string
+	}{
+		{name: "empty disables signing", value: ""},
+		{name: "valid seed", value: base64.StdEncoding.EncodeToString(seed), wantKey: true},
+		{name: "surrounding whitespace", value: " " + base64.StdEncoding.EncodeToString(seed) + "\n", wantKey: true},
+		{name: "invalid base64", value: "not base64!", wantErr: "not valid base64"},
+		{name: "wrong length", value: base64.StdEncoding.EncodeToString(seed[:16]), wantErr: "32-byte"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := parseSigningKey(tt.value)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (key != nil) != tt.wantKey {
+				t.Fatalf("key = %v, want key: %v", key, tt.wantKey)
+			}
+			if tt.wantKey && !bytes.Equal(key.Seed(), seed) {
+				t.Error("key was not derived from the seed")
+			}
+		})
+	}
+}
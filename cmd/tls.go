@@ -0,0 +1,91 @@
+package main
+
+import (
+	"cmp"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// tlsSetup returns the TLS configuration for the main listener and the handler
+// for the plain HTTP listener. In ACME mode that handler also answers http-01
+// challenges; otherwise it only redirects to HTTPS.
+func (app *application) tlsSetup() (*tls.Config, http.Handler, error) {
+	cfg := app.config
+	redirect := http.HandlerFunc(app.redirectToHTTPS)
+
+	switch {
+	case cfg.tlsCertFile != "":
+		cert, err := tls.LoadX509KeyPair(cfg.tlsCertFile, cfg.tlsKeyFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("loading TLS certificate: %w", err)
+		}
+		return &tls.Config{MinVersion: tls.VersionTLS12, Certificates: []tls.Certificate{cert}}, redirect, nil
+	case len(cfg.acmeDomains) > 0:
+		manager, err := app.acmeManager()
+		if err != nil {
+			return nil, nil, err
+		}
+		tlsConfig := manager.TLSConfig()
+		tlsConfig.MinVersion = tls.VersionTLS12
+		return tlsConfig, manager.HTTPHandler(redirect), nil
+	default:
+		return nil, nil, nil
+	}
+}
+
+// acmeManager obtains certificates for ACME_DOMAINS and caches them in
+// ACME_CACHE_DIR. ACME_DIRECTORY_URL and ACME_CA_ROOT point it at another CA,
+// such as a local pebble instance.
+func (app *application) acmeManager() (*autocert.Manager, error) {
+	cfg := app.config
+	client := &acme.Client{DirectoryURL: cfg.acmeDirectoryURL}
+
+	if cfg.acmeCARoot != "" {
+		rootPEM, err := os.ReadFile(cfg.acmeCARoot)
+		if err != nil {
+			return nil, fmt.Errorf("reading ACME_CA_ROOT: %w", err)
+		}
+		roots := x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(rootPEM) {
+			return nil, fmt.Errorf("ACME_CA_ROOT %s contains no PEM certificates", cfg.acmeCARoot)
+		}
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = &tls.Config{RootCAs: roots}
+		client.HTTPClient = &http.Client{Transport: transport}
+	}
+
+	return &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		Cache:      autocert.DirCache(cfg.acmeCacheDir),
+		HostPolicy: autocert.HostWhitelist(cfg.acmeDomains...),
+		Email:      cfg.acmeEmail,
+		Client:     client,
+	}, nil
+}
+
+// redirectToHTTPS sends plain HTTP requests to the same URL on the TLS port:
+// the port the main listener bound, else the one configured, else 443.
+func (app *application) redirectToHTTPS(w http.ResponseWriter, r *http.Request) {
+	port := cmp.Or(app.tlsPort, app.config.httpsPort(), 443)
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.Trim(host, "[]")
+	if port != 443 {
+		host = net.JoinHostPort(host, strconv.Itoa(port))
+	} else if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+
+	http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusPermanentRedirect)
+}
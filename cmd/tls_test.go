@@ -0,0 +1,264 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// writeTestCertificate writes a self-signed certificate for localhost and
+// returns the certificate and key paths.
+func writeTestCertificate(t *testing.T) (string, string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func TestTLSSetupErrors(t *testing.T) {
+	_, keyFile := writeTestCertificate(t)
+
+	tests := []struct {
+		name string
+		cfg  config
+		want string
+	}{
+		{"missing cert", config{tlsCertFile: filepath.Join(t.TempDir(), "missing.pem"), tlsKeyFile: keyFile}, "loading TLS certificate"},
+		{"invalid ca root", config{acmeDomains: []string{"example.com"}, acmeCARoot: keyFile}, "no PEM certificates"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApp()
+			app.config = tt.cfg
+
+			_, _, err := app.tlsSetup()
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("tlsSetup() error = %v, want containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestTLSSetupDisabled(t *testing.T) {
+	app := newTestApp()
+
+	tlsConfig, handler, err := app.tlsSetup()
+	if err != nil || tlsConfig != nil || handler != nil {
+		t.Errorf("tlsSetup() = %v, %v, %v, want all nil", tlsConfig, handler, err)
+	}
+}
+
+func TestTLSSetupACME(t *testing.T) {
+	app := newTestApp()
+	app.config.acmeDomains = []string{"commit.example.com"}
+	app.config.acmeCacheDir = t.TempDir()
+
+	tlsConfig, handler, err := app.tlsSetup()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tlsConfig.GetCertificate == nil {
+		t.Error("GetCertificate is not set")
+	}
+
+	manager, err := app.acmeManager()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := manager.HostPolicy(context.Background(), "commit.example.com"); err != nil {
+		t.Errorf("HostPolicy(commit.example.com) = %v, want nil", err)
+	}
+	if err := manager.HostPolicy(context.Background(), "other.example.com"); err == nil {
+		t.Error("HostPolicy(other.example.com) = nil, want error")
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "http://commit.example.com/commit.sh", nil)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusPermanentRedirect {
+		t.Errorf("status = %d, want %d", rr.Code, http.StatusPermanentRedirect)
+	}
+}
+
+func TestRedirectToHTTPS(t *testing.T) {
+	tests := []struct {
+		port   int
+		listen string
+		target string
+		want   string
+	}{
+		{443, "", "http://commit.example.com/commit.sh?model=x", "https://commit.example.com/commit.sh?model=x"},
+		{443, "", "http://commit.example.com:80/", "https://commit.example.com/"},
+		{8443, "", "http://commit.example.com:8080/", "https://commit.example.com:8443/"},
+		{443, "", "http://[::1]:80/", "https://[::1]/"},
+		{8443, "", "http://[::1]/", "https://[::1]:8443/"},
+		{80, ":8443", "http://commit.example.com/", "https://commit.example.com:8443/"},
+		{80, "unix:/run/commit.sock", "http://commit.example.com/", "https://commit.example.com/"},
+	}
+
+	for _, tt := range tests {
+		app := newTestApp()
+		app.config.appPort = tt.port
+		app.config.listen = tt.listen
+		req := httptest.NewRequest(http.MethodGet, tt.target, nil)
+		rr := httptest.NewRecorder()
+
+		app.redirectToHTTPS(rr, req)
+
+		if rr.Code != http.StatusPermanentRedirect {
+			t.Errorf("%s: status = %d, want %d", tt.target, rr.Code, http.StatusPermanentRedirect)
+		}
+		if got := rr.Header().Get("Location"); got != tt.want {
+			t.Errorf("%s on port %d: Location = %q, want %q", tt.target, tt.port, got, tt.want)
+		}
+	}
+}
+
+func TestServeScriptOverTLS(t *testing.T) {
+	certFile, keyFile := writeTestCertificate(t)
+	app := newTestApp()
+	app.config.appEnv = "development"
+	app.config.tlsCertFile = certFile
+	app.config.tlsKeyFile = keyFile
+
+	tlsConfig, _, err := app.tlsSetup()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewUnstartedServer(app.routes())
+	server.TLS = tlsConfig
+	server.StartTLS()
+	defer server.Close()
+
+	client := server.Client()
+	client.Transport.(*http.Transport).TLSClientConfig.InsecureSkipVerify = true
+
+	req, err := http.NewRequest(http.MethodGet, server.URL+"/commit.sh", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Host = "localhost"
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(body), "SCRIPT_URL='https://localhost'") {
+		t.Error("script served over TLS does not use an https SCRIPT_URL")
+	}
+}
+
+func TestRedirectToListenPort(t *testing.T) {
+	certFile, keyFile := writeTestCertificate(t)
+	free, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	redirectPort := free.Addr().(*net.TCPAddr).Port
+	free.Close()
+
+	app := newTestApp()
+	app.config.appPort = 80
+	app.config.listen = "127.0.0.1:0"
+	app.config.tlsCertFile = certFile
+	app.config.tlsKeyFile = keyFile
+	app.config.httpRedirectPort = redirectPort
+	app.config.shutdownTimeout = time.Second
+	app.config.brand = defaultBranding
+	if err := app.config.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	listener, err := app.listen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- app.serveListener(listener) }()
+
+	client := &http.Client{
+		Timeout:       time.Second,
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+	redirectURL := fmt.Sprintf("http://127.0.0.1:%d/commit.sh", redirectPort)
+	var resp *http.Response
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if resp, err = client.Get(redirectURL); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	want := fmt.Sprintf("https://127.0.0.1:%d/commit.sh", listener.Addr().(*net.TCPAddr).Port)
+	if got := resp.Header.Get("Location"); got != want {
+		t.Errorf("Location = %q, want %q", got, want)
+	}
+
+	if err := syscall.Kill(syscall.Getpid(), syscall.SIGTERM); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("serveListener() = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("server did not shut down")
+	}
+}
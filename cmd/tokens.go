@@ -0,0 +1,225 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+const tokenPrefix = "cmt_"
+
+var errUnknownToken = errors.New("unknown or revoked token")
+
+const tokenSchema = `
+CREATE TABLE IF NOT EXISTS tokens (
+	id             TEXT PRIMARY KEY,
+	user           TEXT NOT NULL,
+	hash           TEXT NOT NULL UNIQUE,
+	daily_requests INTEGER,
+	daily_tokens   INTEGER,
+	created_at     TEXT NOT NULL,
+	revoked_at     TEXT
+);
+CREATE TABLE IF NOT EXISTS usage (
+	token_id TEXT NOT NULL REFERENCES tokens (id),
+	day      TEXT NOT NULL,
+	requests INTEGER NOT NULL DEFAULT 0,
+	tokens   INTEGER NOT NULL DEFAULT 0,
+	PRIMARY KEY (token_id, day)
+);
+`
+
+// tokenStore keeps the gateway's bearer tokens and their daily usage in a
+// SQLite file, so the admin commands can change it while the server runs.
+// Only the SHA-256 of each token is stored.
+type tokenStore struct {
+	db *sql.DB
+}
+
+// quota limits a token per UTC day. Zero means unlimited.
+type quota struct {
+	requests int64
+	tokens   int64
+}
+
+type tokenRecord struct {
+	id        string
+	user      string
+	quota     quota
+	createdAt time.Time
+	revokedAt time.Time
+}
+
+type tokenUsage struct {
+	requests int64
+	tokens   int64
+}
+
+func openTokenStore(path string) (*tokenStore, error) {
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_pragma=foreign_keys(1)")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec(tokenSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("opening %s: %w", path, err)
+	}
+	return &tokenStore{db: db}, nil
+}
+
+func (s *tokenStore) Close() error {
+	return s.db.Close()
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomBytes(n int) []byte {
+	b := make([]byte, n)
+	rand.Read(b)
+	return b
+}
+
+// create issues a token for user and returns it. The token itself is not
+// stored and cannot be shown again. A nil limit uses the server default.
+func (s *tokenStore) create(user string, dailyRequests, dailyTokens *int64, now time.Time) (string, tokenRecord, error) {
+	record := tokenRecord{
+		id:        "tok_" + hex.EncodeToString(randomBytes(6)),
+		user:      user,
+		createdAt: now.UTC(),
+	}
+	token := tokenPrefix + base64.RawURLEncoding.EncodeToString(randomBytes(32))
+
+	_, err := s.db.Exec(`INSERT INTO tokens (id, user, hash, daily_requests, daily_tokens, created_at) VALUES (?, ?, ?, ?, ?, ?)`,
+		record.id, user, hashToken(token), dailyRequests, dailyTokens, record.createdAt.Format(time.RFC3339))
+	if err != nil {
+		return "", record, err
+	}
+	return token, record, nil
+}
+
+// revoke revokes the token with the given ID.
+func (s *tokenStore) revoke(id string, now time.Time) error {
+	result, err := s.db.Exec(`UPDATE tokens SET revoked_at = ? WHERE id = ? AND revoked_at IS NULL`, now.UTC().Format(time.RFC3339), id)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("no active token with ID %q", id)
+	}
+	return nil
+}
+
+// authenticate returns the active token matching token, with the server
+// defaults applied to limits the token does not set.
+func (s *tokenStore) authenticate(token string, defaults quota) (tokenRecord, error) {
+	if !strings.HasPrefix(token, tokenPrefix) {
+		return tokenRecord{}, errUnknownToken
+	}
+	row := s.db.QueryRow(`SELECT id, user, daily_requests, daily_tokens, created_at, revoked_at FROM tokens WHERE hash = ?`, hashToken(token))
+	record, err := scanToken(row, defaults)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && !record.revokedAt.IsZero()) {
+		return tokenRecord{}, errUnknownToken
+	}
+	return record, err
+}
+
+// list returns every token with its usage on day.
+func (s *tokenStore) list(defaults quota, day string) ([]tokenRecord, []tokenUsage, error) {
+	rows, err := s.db.Query(`
+		SELECT t.id, t.user, t.daily_requests, t.daily_tokens, t.created_at, t.revoked_at,
+		       COALESCE(u.requests, 0), COALESCE(u.tokens, 0)
+		FROM tokens t LEFT JOIN usage u ON u.token_id = t.id AND u.day = ?
+		ORDER BY t.created_at, t.id`, day)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	var records []tokenRecord
+	var usages []tokenUsage
+	for rows.Next() {
+		var usage tokenUsage
+		record, err := scanToken(rows, defaults, &usage.requests, &usage.tokens)
+		if err != nil {
+			return nil, nil, err
+		}
+		records = append(records, record)
+		usages = append(usages, usage)
+	}
+	return records, usages, rows.Err()
+}
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanToken(row scanner, defaults quota, extra ...any) (tokenRecord, error) {
+	var (
+		record                     tokenRecord
+		dailyRequests, dailyTokens sql.NullInt64
+		createdAt                  string
+		revokedAt                  sql.NullString
+	)
+	dest := append([]any{&record.id, &record.user, &dailyRequests, &dailyTokens, &createdAt, &revokedAt}, extra...)
+	if err := row.Scan(dest...); err != nil {
+		return record, err
+	}
+
+	record.quota = defaults
+	if dailyRequests.Valid {
+		record.quota.requests = dailyRequests.Int64
+	}
+	if dailyTokens.Valid {
+		record.quota.tokens = dailyTokens.Int64
+	}
+	record.createdAt, _ = time.Parse(time.RFC3339, createdAt)
+	if revokedAt.Valid {
+		record.revokedAt, _ = time.Parse(time.RFC3339, revokedAt.String)
+	}
+	return record, nil
+}
+
+// reserve counts one request for the token on day, unless its request or
+// token quota for the day is already used up.
+func (s *tokenStore) reserve(record tokenRecord, day string) (bool, error) {
+	result, err := s.db.Exec(`
+		INSERT INTO usage (token_id, day, requests) VALUES (?, ?, 1)
+		ON CONFLICT (token_id, day) DO UPDATE SET requests = requests + 1
+		WHERE (? = 0 OR usage.requests < ?) AND (? = 0 OR usage.tokens < ?)`,
+		record.id, day,
+		record.quota.requests, record.quota.requests,
+		record.quota.tokens, record.quota.tokens)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n == 1, err
+}
+
+// addTokens records the model tokens a request used.
+func (s *tokenStore) addTokens(id, day string, tokens int64) error {
+	_, err := s.db.Exec(`UPDATE usage SET tokens = tokens + ? WHERE token_id = ? AND day = ?`, tokens, id, day)
+	return err
+}
+
+// usageDay returns the UTC day quotas are counted against.
+func usageDay(now time.Time) string {
+	return now.UTC().Format(time.DateOnly)
+}
+
+// untilNextDay returns the time left until quotas reset at midnight UTC.
+func untilNextDay(now time.Time) time.Duration {
+	now = now.UTC()
+	return time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC).Sub(now)
+}
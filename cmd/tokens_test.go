@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestTokenStore(t *testing.T) (*tokenStore, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "commit.db")
+	store, err := openTokenStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store, path
+}
+
+func TestTokenStore(t *testing.T) {
+	store, path := newTestTokenStore(t)
+	defaults := quota{requests: 10, tokens: 1000}
+	now := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+
+	limit := int64(3)
+	token, created, err := store.create("alice", &limit, nil, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	record, err := store.authenticate(token, defaults)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record.id != created.id || record.user != "alice" {
+		t.Errorf("authenticate() = %+v", record)
+	}
+	if record.quota != (quota{requests: 3, tokens: 1000}) {
+		t.Errorf("quota = %+v, want the token's request limit and the default token limit", record.quota)
+	}
+
+	for _, wrong := range []string{"", "cmt_wrong", token + "x", token[len(tokenPrefix):]} {
+		if _, err := store.authenticate(wrong, defaults); !errors.Is(err, errUnknownToken) {
+			t.Errorf("authenticate(%q) error = %v, want errUnknownToken", wrong, err)
+		}
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(content, []byte(token)) {
+		t.Error("the token is stored in plain text")
+	}
+
+	if err := store.revoke(created.id, now); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.authenticate(token, defaults); !errors.Is(err, errUnknownToken) {
+		t.Errorf("authenticate() after revoke error = %v, want errUnknownToken", err)
+	}
+	if err := store.revoke(created.id, now); err == nil {
+		t.Error("revoking twice error = nil")
+	}
+}
+
+func TestTokenStoreQuotas(t *testing.T) {
+	store, _ := newTestTokenStore(t)
+	now := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	day, nextDay := usageDay(now), usageDay(now.Add(24*time.Hour))
+
+	token, _, err := store.create("bob", nil, nil, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	record, err := store.authenticate(token, quota{requests: 2, tokens: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, want := range []bool{true, true, false} {
+		if ok, err := store.reserve(record, day); err != nil || ok != want {
+			t.Errorf("reserve #%d = %v, %v, want %v", i+1, ok, err, want)
+		}
+	}
+	if ok, _ := store.reserve(record, nextDay); !ok {
+		t.Error("quota did not reset on the next day")
+	}
+
+	if err := store.addTokens(record.id, nextDay, 100); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := store.reserve(record, nextDay); ok {
+		t.Error("reserve allowed a request after the token quota was used up")
+	}
+
+	unlimited := record
+	unlimited.quota = quota{}
+	for range 5 {
+		if ok, _ := store.reserve(unlimited, day); !ok {
+			t.Fatal("reserve refused a request without limits")
+		}
+	}
+
+	records, usages, err := store.list(quota{requests: 2, tokens: 100}, nextDay)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || usages[0] != (tokenUsage{requests: 1, tokens: 100}) {
+		t.Errorf("list() = %+v, %+v", records, usages)
+	}
+}
+
+func TestUntilNextDay(t *testing.T) {
+	now := time.Date(2026, 10, 17, 23, 59, 30, 0, time.FixedZone("EST", -5*3600))
+	if got, want := untilNextDay(now), 19*time.Hour+30*time.Second; got != want {
+		t.Errorf("untilNextDay() = %s, want %s", got, want)
+	}
+}
@@ -2,70 +2,209 @@ package main
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"fmt"
+	"net"
 	"net/http"
+	"net/netip"
 	"strings"
 )
 
-var errorTemplate = pageTemplate("templates/error.html")
+const (
+	latestCacheControl    = "public, max-age=300"
+	immutableCacheControl = "public, max-age=31536000, immutable"
+)
 
 type errorPageData struct {
 	Title      string
+	Nonce      string
+	Brand      branding
 	StatusCode int
 	Message    string
 }
 
-func (app *application) domain(r *http.Request) string {
-	host := r.Host
-	var proto string
-
-	if app.config.appEnv == "production" {
-		proto = "https"
-	} else {
-		proto = r.Header.Get("X-Forwarded-Proto")
-		if proto == "" {
-			if r.TLS != nil {
-				proto = "https"
-			} else {
-				proto = "http"
+// clientIP returns the address of the client. X-Forwarded-For is only used
+// when the connection comes from a trusted proxy, and is read right to left so
+// a client cannot prepend a spoofed address.
+func (app *application) clientIP(r *http.Request) string {
+	remote := remoteIP(r)
+	if !app.fromTrustedProxy(r) {
+		return remote
+	}
+
+	client := remote
+	if addr, err := netip.ParseAddr(remote); err == nil {
+		client = addr.String()
+	}
+	forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		hop, err := netip.ParseAddr(strings.TrimSpace(forwarded[i]))
+		if err != nil {
+			break
+		}
+		hop = hop.Unmap()
+		client = hop.String()
+		if !app.isTrustedProxy(hop) {
+			break
+		}
+	}
+	return client
+}
+
+// fromTrustedProxy reports whether the request arrived from a trusted proxy:
+// a peer in TRUSTED_PROXIES, or any peer on a Unix socket, which only local
+// processes allowed by the socket's permissions can connect to.
+func (app *application) fromTrustedProxy(r *http.Request) bool {
+	if local, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr); ok && local.Network() == "unix" {
+		return true
+	}
+	addr, err := netip.ParseAddr(remoteIP(r))
+	return err == nil && app.isTrustedProxy(addr)
+}
+
+func (app *application) isTrustedProxy(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, prefix := range app.config.trustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseTrustedProxies parses a comma-separated list of IP addresses and CIDR
+// ranges, e.g. "10.0.0.0/8, 127.0.0.1".
+func parseTrustedProxies(value string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, field := range strings.Split(value, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+
+		if strings.Contains(field, "/") {
+			prefix, err := netip.ParsePrefix(field)
+			if err != nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q: %w", field, err)
 			}
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+
+		addr, err := netip.ParseAddr(field)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", field, err)
 		}
+		prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+	}
+	return prefixes, nil
+}
+
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
 	}
+	return host
+}
 
-	return proto + "://" + host
+// clientKind groups requests the same way the handlers choose a response:
+// "json", "browser", the name of a known command line client such as "curl"
+// or "wget", or "script" for other clients that asked for the script.
+func clientKind(r *http.Request) string {
+	switch negotiate(r, mediaHTML, mediaJSON, mediaScript) {
+	case mediaJSON:
+		return "json"
+	case mediaScript:
+		if fetcher := cliFetcher(r); fetcher != "" {
+			return fetcher
+		}
+		return "script"
+	default:
+		return "browser"
+	}
 }
 
-func respond(w http.ResponseWriter, r *http.Request, statusCode int, message string) {
-	accept := r.Header.Get("Accept")
-	userAgent := r.Header.Get("User-Agent")
+// writeProblem writes p as application/problem+json when the client asks for
+// it, as {"message": ...} to other JSON and command line clients, and as the
+// HTML error page otherwise.
+func (app *application) writeProblem(w http.ResponseWriter, r *http.Request, p problem) {
+	if p.Title == "" {
+		p.Title = http.StatusText(p.Status)
+	}
+	if p.Title == "" {
+		p.Title = "Error"
+	}
+	p.Instance = requestID(r)
+
+	varyNegotiated(w)
+	representation := negotiate(r, mediaHTML, mediaProblem, mediaJSON, mediaScript)
+
+	if representation == mediaProblem {
+		w.Header().Set("Content-Type", "application/problem+json")
+		w.WriteHeader(p.Status)
+		if err := json.NewEncoder(w).Encode(p); err != nil {
+			return
+		}
+		return
+	}
 
-	if strings.Contains(accept, "application/json") || strings.Contains(userAgent, "curl") {
+	if representation == mediaJSON || representation == mediaScript {
 		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(statusCode)
-		if err := json.NewEncoder(w).Encode(map[string]string{"message": message}); err != nil {
+		w.WriteHeader(p.Status)
+		if err := json.NewEncoder(w).Encode(map[string]string{"message": p.Detail}); err != nil {
 			return
 		}
 		return
 	}
 
-	statusText := http.StatusText(statusCode)
-	if statusText == "" {
-		statusText = "Error"
+	tmpl, err := siteAssets.page("error.html")
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
 	}
 
 	var page bytes.Buffer
-	if err := errorTemplate.ExecuteTemplate(&page, "base.html", errorPageData{
-		Title:      statusText,
-		StatusCode: statusCode,
-		Message:    message,
+	if err := tmpl.ExecuteTemplate(&page, "base.html", errorPageData{
+		Title:      p.Title,
+		Nonce:      cspNonce(r),
+		Brand:      app.brand(),
+		StatusCode: p.Status,
+		Message:    p.Detail,
 	}); err != nil {
 		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 		return
 	}
 
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	w.WriteHeader(statusCode)
+	w.WriteHeader(p.Status)
 	if _, err := page.WriteTo(w); err != nil {
 		return
 	}
 }
+
+func strongETag(content []byte) string {
+	sum := sha256.Sum256(content)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+// notModified sets the ETag header and reports whether If-None-Match already
+// matches it, in which case a 304 Not Modified has been written.
+func notModified(w http.ResponseWriter, r *http.Request, etag string) bool {
+	w.Header().Set("ETag", etag)
+
+	ifNoneMatch := r.Header.Get("If-None-Match")
+	if ifNoneMatch == "" {
+		return false
+	}
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return true
+		}
+	}
+	return false
+}
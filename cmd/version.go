@@ -0,0 +1,45 @@
+package main
+
+import (
+	"runtime"
+	"runtime/debug"
+)
+
+// version is set at build time with -ldflags "-X main.version=...".
+var version = "dev"
+
+// buildInfo describes the running binary for /version.
+type buildInfo struct {
+	Version       string `json:"version"`
+	ModuleVersion string `json:"module_version,omitempty"`
+	Revision      string `json:"revision,omitempty"`
+	BuildTime     string `json:"build_time,omitempty"`
+	Modified      bool   `json:"modified"`
+	GoVersion     string `json:"go_version"`
+}
+
+func readBuildInfo() buildInfo {
+	build := buildInfo{Version: version, GoVersion: runtime.Version()}
+
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return build
+	}
+
+	build.ModuleVersion = info.Main.Version
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			build.Revision = setting.Value
+		case "vcs.time":
+			build.BuildTime = setting.Value
+		case "vcs.modified":
+			build.Modified = setting.Value == "true"
+		}
+	}
+	return build
+}
+
+func buildRevision() string {
+	return readBuildInfo().Revision
+}
@@ -0,0 +1,6 @@
+package docs
+
+import "embed"
+
+//go:embed *.md
+var Embeddedfiles embed.FS